@@ -0,0 +1,106 @@
+package certificate
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/Qubitopia/quantum-scholar-backend/database"
+)
+
+// Credential is the signed statement behind a certificate. The short keys keep the
+// token small enough for a QR code.
+type Credential struct {
+	ID       string  `json:"id"`
+	Holder   string  `json:"h"`
+	Test     string  `json:"t"`
+	Score    float64 `json:"s"`
+	MaxScore float64 `json:"m"`
+	Passed   bool    `json:"p"`
+	IssuedAt int64   `json:"i"` // unix seconds
+}
+
+var (
+	signingKey ed25519.PrivateKey
+	// PublicKey verifies credential signatures, it is published so anyone can check a token offline
+	PublicKey ed25519.PublicKey
+)
+
+var (
+	ErrInvalidSignature = errors.New("credential signature is not valid")
+	ErrNoSigningKey     = errors.New("certificate signing is not configured")
+)
+
+// InitSigner loads the Ed25519 key from CERTIFICATE_SIGNING_KEY, the base64 of a 32 byte seed.
+// Without one no credentials are issued or verified, a key that is set but malformed stops the server.
+func InitSigner() {
+	if database.CERTIFICATE_SIGNING_KEY == "" {
+		log.Println("CERTIFICATE_SIGNING_KEY is not set, credentials and certificates will not be issued")
+		return
+	}
+	seed, err := base64.StdEncoding.DecodeString(database.CERTIFICATE_SIGNING_KEY)
+	if err != nil || len(seed) != ed25519.SeedSize {
+		log.Fatalf("CERTIFICATE_SIGNING_KEY must be the base64 of %d random bytes", ed25519.SeedSize)
+	}
+	signingKey = ed25519.NewKeyFromSeed(seed)
+	PublicKey = signingKey.Public().(ed25519.PublicKey)
+}
+
+// Enabled reports whether a signing key was loaded
+func Enabled() bool {
+	return signingKey != nil
+}
+
+// Sign returns the credential's JSON payload and its base64url signature
+func Sign(c Credential) (payload string, signature string, err error) {
+	if !Enabled() {
+		return "", "", ErrNoSigningKey
+	}
+	payloadBytes, err := json.Marshal(c)
+	if err != nil {
+		return "", "", err
+	}
+	return string(payloadBytes), base64.RawURLEncoding.EncodeToString(ed25519.Sign(signingKey, payloadBytes)), nil
+}
+
+// VerifySignature checks a stored payload against its signature
+func VerifySignature(payload, signature string) bool {
+	if !Enabled() {
+		return false
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	return ed25519.Verify(PublicKey, []byte(payload), sig)
+}
+
+// Token packs a payload and signature into "<base64url payload>.<base64url signature>" for the QR code
+func Token(payload, signature string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." + signature
+}
+
+// ParseToken checks a token's signature and returns the credential and payload it carries
+func ParseToken(token string) (Credential, string, error) {
+	var c Credential
+	encodedPayload, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return c, "", fmt.Errorf("malformed credential token")
+	}
+	payloadBytes, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return c, "", fmt.Errorf("malformed credential token")
+	}
+	payload := string(payloadBytes)
+	if !VerifySignature(payload, signature) {
+		return c, "", ErrInvalidSignature
+	}
+	if err := json.Unmarshal(payloadBytes, &c); err != nil {
+		return c, "", fmt.Errorf("malformed credential payload")
+	}
+	return c, payload, nil
+}
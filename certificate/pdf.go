@@ -0,0 +1,217 @@
+package certificate
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+// Data is what gets printed on a certificate
+type Data struct {
+	CertificateID string
+	HolderName    string
+	TestName      string
+	Score         float64
+	MaxScore      float64
+	IssuedAt      time.Time
+	VerifyURL     string // printed on the certificate
+	QRContent     string // encoded in the QR code, the verify link with the signed token
+}
+
+// A4 landscape in points
+const (
+	pageWidth  = 842.0
+	pageHeight = 595.0
+)
+
+// Glyph widths of the standard Helvetica fonts for ASCII 32 to 126, in 1/1000 of the font size
+var helveticaWidths = [95]int{
+	278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+	1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+	333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+	556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+}
+
+var helveticaBoldWidths = [95]int{
+	278, 333, 474, 556, 556, 889, 722, 238, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 333, 333, 584, 584, 584, 611,
+	975, 722, 722, 722, 722, 667, 611, 778, 722, 278, 556, 722, 611, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 333, 278, 333, 584, 556,
+	333, 556, 611, 556, 611, 556, 333, 611, 611, 278, 278, 556, 278, 889, 611, 611,
+	611, 611, 389, 556, 333, 611, 556, 778, 556, 556, 500, 389, 280, 389, 584,
+}
+
+type font struct {
+	resource string // name in the page resources
+	widths   *[95]int
+}
+
+var (
+	regular = font{"F1", &helveticaWidths}
+	bold    = font{"F2", &helveticaBoldWidths}
+)
+
+// RenderPDF draws a one page A4 landscape certificate using only the standard PDF fonts
+func RenderPDF(d Data) ([]byte, error) {
+	qr, err := EncodeQR(d.QRContent)
+	if err != nil {
+		// Very long names can make the token too big, the plain link still verifies online
+		if qr, err = EncodeQR(d.VerifyURL); err != nil {
+			return nil, err
+		}
+	}
+
+	var content bytes.Buffer
+	margin := 28.0
+
+	// Double border
+	content.WriteString("0.13 0.27 0.53 RG 3 w\n")
+	fmt.Fprintf(&content, "%.2f %.2f %.2f %.2f re S\n", margin, margin, pageWidth-2*margin, pageHeight-2*margin)
+	content.WriteString("1 w\n")
+	fmt.Fprintf(&content, "%.2f %.2f %.2f %.2f re S\n", margin+8, margin+8, pageWidth-2*margin-16, pageHeight-2*margin-16)
+
+	maxWidth := pageWidth - 2*margin - 80
+	content.WriteString("0.13 0.27 0.53 rg\n")
+	centeredText(&content, bold, 14, 515, maxWidth, "QUANTUM SCHOLAR")
+	centeredText(&content, bold, 34, 460, maxWidth, "CERTIFICATE OF ACHIEVEMENT")
+	content.WriteString("0.2 0.2 0.2 rg\n")
+	centeredText(&content, regular, 16, 410, maxWidth, "This is to certify that")
+	centeredText(&content, bold, 30, 360, maxWidth, d.HolderName)
+	centeredText(&content, regular, 16, 318, maxWidth, "has successfully passed")
+	centeredText(&content, bold, 22, 282, maxWidth, d.TestName)
+	percent := 0.0
+	if d.MaxScore > 0 {
+		percent = 100 * d.Score / d.MaxScore
+	}
+	centeredText(&content, regular, 16, 240, maxWidth, fmt.Sprintf("with a score of %g out of %g (%.1f%%)", d.Score, d.MaxScore, percent))
+
+	// Issue details bottom left, QR code bottom right
+	textAt(&content, regular, 12, 80, 130, "Issued on "+d.IssuedAt.UTC().Format("2 January 2006"))
+	textAt(&content, regular, 10, 80, 112, "Certificate ID: "+d.CertificateID)
+	textAt(&content, regular, 8, 80, 96, "Verify at "+d.VerifyURL)
+
+	qrSide := 120.0
+	qrX, qrY := pageWidth-80-qrSide, 70.0
+	drawQR(&content, qr, qrX, qrY, qrSide)
+	centeredTextAt(&content, regular, 8, qrX+qrSide/2, qrY-10, "Scan to verify")
+
+	return buildPDF(content.Bytes())
+}
+
+// drawQR fills the dark modules inside a square that includes the 4 module quiet zone
+func drawQR(w *bytes.Buffer, qr *QRCode, x, y, side float64) {
+	module := side / float64(qr.Size+8)
+	w.WriteString("0 0 0 rg\n")
+	for row := 0; row < qr.Size; row++ {
+		for col := 0; col < qr.Size; col++ {
+			if !qr.Modules[row][col] {
+				continue
+			}
+			// PDF y grows upwards, QR rows grow downwards
+			fmt.Fprintf(w, "%.3f %.3f %.3f %.3f re\n",
+				x+float64(col+4)*module, y+side-float64(row+5)*module, module, module)
+		}
+	}
+	w.WriteString("f\n")
+}
+
+// centeredText draws a line centred on the page, shrinking the font until it fits maxWidth
+func centeredText(w *bytes.Buffer, f font, size, y, maxWidth float64, text string) {
+	for size > 8 && textWidth(f, size, text) > maxWidth {
+		size--
+	}
+	centeredTextAt(w, f, size, pageWidth/2, y, text)
+}
+
+func centeredTextAt(w *bytes.Buffer, f font, size, centerX, y float64, text string) {
+	textAt(w, f, size, centerX-textWidth(f, size, text)/2, y, text)
+}
+
+func textAt(w *bytes.Buffer, f font, size, x, y float64, text string) {
+	fmt.Fprintf(w, "BT /%s %.1f Tf %.2f %.2f Td (%s) Tj ET\n", f.resource, size, x, y, pdfString(text))
+}
+
+func textWidth(f font, size float64, text string) float64 {
+	units := 0
+	for _, b := range winAnsi(text) {
+		if b >= 32 && b <= 126 {
+			units += f.widths[b-32]
+		} else {
+			units += 556 // close enough for accented letters
+		}
+	}
+	return float64(units) * size / 1000
+}
+
+// winAnsi converts text to the encoding of the standard fonts, characters it cannot show become '?'
+func winAnsi(text string) []byte {
+	out := make([]byte, 0, len(text))
+	for _, r := range text {
+		b, ok := charmap.Windows1252.EncodeRune(r)
+		if !ok || b < 32 {
+			b = '?'
+		}
+		out = append(out, b)
+	}
+	return out
+}
+
+func pdfString(text string) string {
+	var b strings.Builder
+	for _, c := range winAnsi(text) {
+		switch c {
+		case '(', ')', '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		default:
+			if c < 128 {
+				b.WriteByte(c)
+			} else {
+				fmt.Fprintf(&b, "\\%03o", c)
+			}
+		}
+	}
+	return b.String()
+}
+
+// buildPDF wraps a page content stream into a complete PDF file with the cross-reference table
+func buildPDF(content []byte) ([]byte, error) {
+	var compressed bytes.Buffer
+	zw := zlib.NewWriter(&compressed)
+	if _, err := zw.Write(content); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %g %g] /Resources << /Font << /F1 5 0 R /F2 6 0 R >> >> /Contents 4 0 R >>", pageWidth, pageHeight),
+		fmt.Sprintf("<< /Length %d /Filter /FlateDecode >>\nstream\n%s\nendstream", compressed.Len(), compressed.String()),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>",
+	}
+
+	var out bytes.Buffer
+	out.WriteString("%PDF-1.4\n%\xE2\xE3\xCF\xD3\n")
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		offsets[i] = out.Len()
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return out.Bytes(), nil
+}
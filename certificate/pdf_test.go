@@ -0,0 +1,164 @@
+package certificate
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ledongthuc/pdf"
+)
+
+var testData = Data{
+	CertificateID: "3f9a1c2b7d",
+	HolderName:    "Zoë (Ana) O'Brien \\ Smith",
+	TestName:      "Quantum Mechanics 101",
+	Score:         87.5,
+	MaxScore:      100,
+	IssuedAt:      time.Date(2026, 10, 18, 9, 30, 0, 0, time.UTC),
+	VerifyURL:     "https://quantumscholar.example/verify/3f9a1c2b7d",
+	QRContent:     "https://quantumscholar.example/verify/3f9a1c2b7d?token=abc.def",
+}
+
+// The cross-reference table must point at each object's header and startxref at the table
+func TestRenderPDFCrossReference(t *testing.T) {
+	out, err := RenderPDF(testData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(out, []byte("%PDF-1.4\n")) || !bytes.HasSuffix(out, []byte("%%EOF\n")) {
+		t.Fatalf("missing header or trailer")
+	}
+
+	m := regexp.MustCompile(`startxref\n(\d+)\n%%EOF\n$`).FindSubmatch(out)
+	if m == nil {
+		t.Fatal("no startxref")
+	}
+	xref, _ := strconv.Atoi(string(m[1]))
+	if !bytes.HasPrefix(out[xref:], []byte("xref\n")) {
+		t.Fatalf("startxref %d points at %q", xref, out[xref:min(xref+10, len(out))])
+	}
+
+	lines := strings.Split(string(out[xref:]), "\n")
+	var first, count int
+	if _, err := fmt.Sscanf(lines[1], "%d %d", &first, &count); err != nil || first != 0 {
+		t.Fatalf("bad subsection header %q", lines[1])
+	}
+	if lines[2] != "0000000000 65535 f " {
+		t.Errorf("object 0 entry is %q", lines[2])
+	}
+	for id := 1; id < count; id++ {
+		entry := lines[2+id]
+		// Every entry is exactly 20 bytes with its end of line
+		if len(entry) != 19 || !strings.HasSuffix(entry, " 00000 n ") {
+			t.Fatalf("object %d entry %q is malformed", id, entry)
+		}
+		offset, _ := strconv.Atoi(entry[:10])
+		if header := fmt.Sprintf("%d 0 obj\n", id); !bytes.HasPrefix(out[offset:], []byte(header)) {
+			t.Errorf("object %d offset %d points at %q", id, offset, out[offset:min(offset+12, len(out))])
+		}
+	}
+	if !regexp.MustCompile(fmt.Sprintf(`trailer\n<< /Size %d /Root 1 0 R >>`, count)).Match(out) {
+		t.Errorf("trailer /Size does not match the %d entries", count)
+	}
+
+	// The stream is exactly /Length bytes long
+	m = regexp.MustCompile(`/Length (\d+) /Filter /FlateDecode >>\nstream\n`).FindSubmatch(out)
+	if m == nil {
+		t.Fatal("no content stream")
+	}
+	length, _ := strconv.Atoi(string(m[1]))
+	start := bytes.Index(out, []byte("stream\n")) + len("stream\n")
+	if !bytes.HasPrefix(out[start+length:], []byte("\nendstream")) {
+		t.Errorf("stream /Length %d does not end at endstream", length)
+	}
+}
+
+// A real parser must open the file through its cross-reference table and find the text and
+// every module of the QR code
+func TestRenderPDFParses(t *testing.T) {
+	out, err := RenderPDF(testData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		// The parser panics on malformed objects
+		if r := recover(); r != nil {
+			t.Fatalf("parsing the PDF: %v", r)
+		}
+	}()
+
+	r, err := pdf.NewReader(bytes.NewReader(out), int64(len(out)))
+	if err != nil {
+		t.Fatalf("opening the PDF: %v", err)
+	}
+	if n := r.NumPage(); n != 1 {
+		t.Fatalf("%d pages, want 1", n)
+	}
+	page := r.Page(1)
+	if box := page.V.Key("MediaBox"); box.Index(2).Float64() != pageWidth || box.Index(3).Float64() != pageHeight {
+		t.Errorf("media box %v, want A4 landscape", box)
+	}
+	for _, name := range []string{"F1", "F2"} {
+		if base := page.Font(name).BaseFont(); !strings.HasPrefix(base, "Helvetica") {
+			t.Errorf("font %s is %q", name, base)
+		}
+	}
+
+	content := page.Content()
+	var text strings.Builder
+	for _, t := range content.Text {
+		text.WriteString(t.S)
+	}
+	for _, want := range []string{
+		"CERTIFICATE OF ACHIEVEMENT",
+		testData.HolderName,
+		testData.TestName,
+		"with a score of 87.5 out of 100 (87.5%)",
+		"Issued on 18 October 2026",
+		"Certificate ID: " + testData.CertificateID,
+		"Verify at " + testData.VerifyURL,
+	} {
+		if !strings.Contains(text.String(), want) {
+			t.Errorf("page text does not contain %q", want)
+		}
+	}
+
+	qr, err := EncodeQR(testData.QRContent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dark := 0
+	for _, row := range qr.Modules {
+		for _, d := range row {
+			if d {
+				dark++
+			}
+		}
+	}
+	// Two border rectangles, then one per dark module
+	if got := len(content.Rect); got != 2+dark {
+		t.Errorf("%d rectangles, want 2 borders and %d QR modules", got, dark)
+	}
+}
+
+func TestPDFString(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"plain", "plain"},
+		{`a (b) \c`, `a \(b\) \\c`},
+		{"Zoë", `Zo\353`},
+		{"名", "?"},
+		{"tab\there", "tab?here"},
+	}
+	for _, tt := range tests {
+		if got := pdfString(tt.text); got != tt.want {
+			t.Errorf("pdfString(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
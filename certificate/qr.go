@@ -0,0 +1,429 @@
+package certificate
+
+import "fmt"
+
+// QR code encoder for the verification link printed on certificates. Only what a
+// certificate needs is supported: byte mode, error correction level M, versions 1 to 20.
+
+// qrBlocks describes the error correction block structure of one version at level M
+type qrBlocks struct {
+	ecPerBlock                     int
+	group1Blocks, group1DataLength int
+	group2Blocks, group2DataLength int
+}
+
+var qrBlocksM = [21]qrBlocks{
+	1: {10, 1, 16, 0, 0}, 2: {16, 1, 28, 0, 0}, 3: {26, 1, 44, 0, 0}, 4: {18, 2, 32, 0, 0},
+	5: {24, 2, 43, 0, 0}, 6: {16, 4, 27, 0, 0}, 7: {18, 4, 31, 0, 0}, 8: {22, 2, 38, 2, 39},
+	9: {22, 3, 36, 2, 37}, 10: {26, 4, 43, 1, 44}, 11: {30, 1, 50, 4, 51}, 12: {22, 6, 36, 2, 37},
+	13: {22, 8, 37, 1, 38}, 14: {24, 4, 40, 5, 41}, 15: {24, 5, 41, 5, 42}, 16: {28, 7, 45, 3, 46},
+	17: {28, 10, 46, 1, 47}, 18: {26, 9, 43, 4, 44}, 19: {26, 3, 44, 11, 45}, 20: {26, 3, 41, 13, 42},
+}
+
+var qrAlignmentPositions = [21][]int{
+	2: {6, 18}, 3: {6, 22}, 4: {6, 26}, 5: {6, 30}, 6: {6, 34},
+	7: {6, 22, 38}, 8: {6, 24, 42}, 9: {6, 26, 46}, 10: {6, 28, 50}, 11: {6, 30, 54},
+	12: {6, 32, 58}, 13: {6, 34, 62}, 14: {6, 26, 46, 66}, 15: {6, 26, 48, 70}, 16: {6, 26, 50, 74},
+	17: {6, 30, 54, 78}, 18: {6, 30, 56, 82}, 19: {6, 30, 58, 86}, 20: {6, 34, 62, 90},
+}
+
+func (b qrBlocks) dataLength() int {
+	return b.group1Blocks*b.group1DataLength + b.group2Blocks*b.group2DataLength
+}
+
+// QRCode is a square grid of modules indexed [y][x], true is dark. It has no quiet zone.
+type QRCode struct {
+	Size    int
+	Modules [][]bool
+	// modules that belong to finder, timing, alignment, format and version patterns
+	function [][]bool
+}
+
+// EncodeQR encodes text in the smallest version that fits, with the mask that scores
+// the lowest penalty
+func EncodeQR(text string) (*QRCode, error) {
+	data := []byte(text)
+
+	version := 0
+	for v := 1; v <= 20; v++ {
+		if 4+countBits(v)+8*len(data) <= 8*qrBlocksM[v].dataLength() {
+			version = v
+			break
+		}
+	}
+	if version == 0 {
+		return nil, fmt.Errorf("text of %d bytes is too long for a QR code", len(data))
+	}
+
+	codewords := addErrorCorrection(version, dataCodewords(version, data))
+
+	qr := newQRCode(version)
+	qr.drawFunctionPatterns(version)
+	qr.drawCodewords(codewords)
+
+	best, bestPenalty := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		qr.applyMask(mask)
+		qr.drawFormatBits(mask)
+		if penalty := qr.penalty(); bestPenalty < 0 || penalty < bestPenalty {
+			best, bestPenalty = mask, penalty
+		}
+		qr.applyMask(mask) // masking twice undoes it
+	}
+	qr.applyMask(best)
+	qr.drawFormatBits(best)
+	return qr, nil
+}
+
+func countBits(version int) int {
+	if version <= 9 {
+		return 8
+	}
+	return 16
+}
+
+// dataCodewords builds the byte mode bit stream and pads it to the version's data capacity
+func dataCodewords(version int, data []byte) []byte {
+	capacity := qrBlocksM[version].dataLength()
+	var bits []bool
+	appendBits := func(value, length int) {
+		for i := length - 1; i >= 0; i-- {
+			bits = append(bits, (value>>i)&1 == 1)
+		}
+	}
+	appendBits(0b0100, 4)
+	appendBits(len(data), countBits(version))
+	for _, b := range data {
+		appendBits(int(b), 8)
+	}
+	// Terminator, then pad to a whole byte
+	appendBits(0, min(4, 8*capacity-len(bits)))
+	appendBits(0, (8-len(bits)%8)%8)
+
+	codewords := make([]byte, 0, capacity)
+	for i := 0; i < len(bits); i += 8 {
+		var b byte
+		for j := 0; j < 8; j++ {
+			if bits[i+j] {
+				b |= 1 << (7 - j)
+			}
+		}
+		codewords = append(codewords, b)
+	}
+	for pad := byte(0xEC); len(codewords) < capacity; pad ^= 0xEC ^ 0x11 {
+		codewords = append(codewords, pad)
+	}
+	return codewords
+}
+
+// addErrorCorrection splits the data into blocks, appends Reed-Solomon codewords to each
+// and interleaves the result
+func addErrorCorrection(version int, data []byte) []byte {
+	blocks := qrBlocksM[version]
+	divisor := reedSolomonDivisor(blocks.ecPerBlock)
+
+	var dataBlocks, ecBlocks [][]byte
+	offset := 0
+	for i := 0; i < blocks.group1Blocks+blocks.group2Blocks; i++ {
+		length := blocks.group1DataLength
+		if i >= blocks.group1Blocks {
+			length = blocks.group2DataLength
+		}
+		block := data[offset : offset+length]
+		offset += length
+		dataBlocks = append(dataBlocks, block)
+		ecBlocks = append(ecBlocks, reedSolomonRemainder(block, divisor))
+	}
+
+	var result []byte
+	for i := 0; i < max(blocks.group1DataLength, blocks.group2DataLength); i++ {
+		for _, block := range dataBlocks {
+			if i < len(block) {
+				result = append(result, block[i])
+			}
+		}
+	}
+	for i := 0; i < blocks.ecPerBlock; i++ {
+		for _, block := range ecBlocks {
+			result = append(result, block[i])
+		}
+	}
+	return result
+}
+
+// reedSolomonDivisor returns the generator polynomial of the given degree, leading term dropped
+func reedSolomonDivisor(degree int) []byte {
+	result := make([]byte, degree)
+	result[degree-1] = 1
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := range result {
+			result[j] = gfMultiply(result[j], root)
+			if j+1 < len(result) {
+				result[j] ^= result[j+1]
+			}
+		}
+		root = gfMultiply(root, 0x02)
+	}
+	return result
+}
+
+func reedSolomonRemainder(data, divisor []byte) []byte {
+	result := make([]byte, len(divisor))
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+		for i := range result {
+			result[i] ^= gfMultiply(divisor[i], factor)
+		}
+	}
+	return result
+}
+
+// gfMultiply multiplies in GF(2^8) modulo x^8 + x^4 + x^3 + x^2 + 1
+func gfMultiply(x, y byte) byte {
+	z := 0
+	for i := 7; i >= 0; i-- {
+		z = (z << 1) ^ ((z >> 7) * 0x11D)
+		z ^= int((y>>i)&1) * int(x)
+	}
+	return byte(z)
+}
+
+func newQRCode(version int) *QRCode {
+	size := 4*version + 17
+	qr := &QRCode{Size: size, Modules: make([][]bool, size), function: make([][]bool, size)}
+	for y := 0; y < size; y++ {
+		qr.Modules[y] = make([]bool, size)
+		qr.function[y] = make([]bool, size)
+	}
+	return qr
+}
+
+func (qr *QRCode) setFunction(x, y int, dark bool) {
+	qr.Modules[y][x] = dark
+	qr.function[y][x] = true
+}
+
+func (qr *QRCode) drawFunctionPatterns(version int) {
+	size := qr.Size
+
+	// Timing patterns
+	for i := 0; i < size; i++ {
+		qr.setFunction(6, i, i%2 == 0)
+		qr.setFunction(i, 6, i%2 == 0)
+	}
+
+	// Finder patterns with their separators
+	for _, center := range [][2]int{{3, 3}, {size - 4, 3}, {3, size - 4}} {
+		for dy := -4; dy <= 4; dy++ {
+			for dx := -4; dx <= 4; dx++ {
+				x, y := center[0]+dx, center[1]+dy
+				if x < 0 || x >= size || y < 0 || y >= size {
+					continue
+				}
+				dist := max(abs(dx), abs(dy))
+				qr.setFunction(x, y, dist != 2 && dist != 4)
+			}
+		}
+	}
+
+	// Alignment patterns, except where they would overlap the finders
+	positions := qrAlignmentPositions[version]
+	last := len(positions) - 1
+	for i, y := range positions {
+		for j, x := range positions {
+			if (i == 0 && j == 0) || (i == 0 && j == last) || (i == last && j == 0) {
+				continue
+			}
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					qr.setFunction(x+dx, y+dy, max(abs(dx), abs(dy)) != 1)
+				}
+			}
+		}
+	}
+
+	// Reserve the format areas, the real bits are drawn once the mask is chosen
+	qr.drawFormatBits(0)
+
+	// Version information from version 7 up
+	if version >= 7 {
+		rem := version
+		for i := 0; i < 12; i++ {
+			rem = (rem << 1) ^ ((rem >> 11) * 0x1F25)
+		}
+		bits := version<<12 | rem
+		for i := 0; i < 18; i++ {
+			dark := (bits>>i)&1 == 1
+			a, b := size-11+i%3, i/3
+			qr.setFunction(a, b, dark)
+			qr.setFunction(b, a, dark)
+		}
+	}
+}
+
+// drawFormatBits writes both copies of the level M format information for the mask
+func (qr *QRCode) drawFormatBits(mask int) {
+	data := mask // level M is 00
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = (rem << 1) ^ ((rem >> 9) * 0x537)
+	}
+	bits := (data<<10 | rem) ^ 0x5412
+	bit := func(i int) bool { return (bits>>i)&1 == 1 }
+
+	size := qr.Size
+	for i := 0; i <= 5; i++ {
+		qr.setFunction(8, i, bit(i))
+	}
+	qr.setFunction(8, 7, bit(6))
+	qr.setFunction(8, 8, bit(7))
+	qr.setFunction(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		qr.setFunction(14-i, 8, bit(i))
+	}
+	for i := 0; i < 8; i++ {
+		qr.setFunction(size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		qr.setFunction(8, size-15+i, bit(i))
+	}
+	qr.setFunction(8, size-8, true) // always dark
+}
+
+// drawCodewords fills the non-function modules in the zigzag order, two columns at a time
+// from the bottom right. Left over remainder modules stay light.
+func (qr *QRCode) drawCodewords(codewords []byte) {
+	size := qr.Size
+	i := 0
+	for right := size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5 // skip the vertical timing pattern
+		}
+		for vert := 0; vert < size; vert++ {
+			for j := 0; j < 2; j++ {
+				x := right - j
+				y := vert
+				if (right+1)&2 == 0 {
+					y = size - 1 - vert // upward
+				}
+				if !qr.function[y][x] && i < len(codewords)*8 {
+					qr.Modules[y][x] = (codewords[i>>3]>>(7-i&7))&1 == 1
+					i++
+				}
+			}
+		}
+	}
+}
+
+func (qr *QRCode) applyMask(mask int) {
+	for y := 0; y < qr.Size; y++ {
+		for x := 0; x < qr.Size; x++ {
+			if qr.function[y][x] {
+				continue
+			}
+			var invert bool
+			switch mask {
+			case 0:
+				invert = (x+y)%2 == 0
+			case 1:
+				invert = y%2 == 0
+			case 2:
+				invert = x%3 == 0
+			case 3:
+				invert = (x+y)%3 == 0
+			case 4:
+				invert = (x/3+y/2)%2 == 0
+			case 5:
+				invert = x*y%2+x*y%3 == 0
+			case 6:
+				invert = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				invert = ((x+y)%2+x*y%3)%2 == 0
+			}
+			qr.Modules[y][x] = qr.Modules[y][x] != invert
+		}
+	}
+}
+
+// penalty scores how hard the symbol is to scan, following the four rules of the specification
+func (qr *QRCode) penalty() int {
+	size := qr.Size
+	at := func(x, y int, transposed bool) bool {
+		if transposed {
+			return qr.Modules[x][y]
+		}
+		return qr.Modules[y][x]
+	}
+
+	penalty := 0
+	finderLike := [][]bool{
+		{true, false, true, true, true, false, true, false, false, false, false},
+		{false, false, false, false, true, false, true, true, true, false, true},
+	}
+	for _, transposed := range []bool{false, true} {
+		for y := 0; y < size; y++ {
+			// Rule 1: runs of five or more modules of the same colour
+			run := 1
+			for x := 1; x < size; x++ {
+				if at(x, y, transposed) == at(x-1, y, transposed) {
+					run++
+					continue
+				}
+				if run >= 5 {
+					penalty += run - 2
+				}
+				run = 1
+			}
+			if run >= 5 {
+				penalty += run - 2
+			}
+
+			// Rule 3: patterns that look like a finder
+			for x := 0; x+11 <= size; x++ {
+				for _, pattern := range finderLike {
+					matches := true
+					for k, dark := range pattern {
+						if at(x+k, y, transposed) != dark {
+							matches = false
+							break
+						}
+					}
+					if matches {
+						penalty += 40
+					}
+				}
+			}
+		}
+	}
+
+	// Rule 2: 2x2 blocks of the same colour
+	dark := 0
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			if qr.Modules[y][x] {
+				dark++
+			}
+			if x+1 < size && y+1 < size {
+				c := qr.Modules[y][x]
+				if c == qr.Modules[y][x+1] && c == qr.Modules[y+1][x] && c == qr.Modules[y+1][x+1] {
+					penalty += 3
+				}
+			}
+		}
+	}
+
+	// Rule 4: balance of dark and light modules
+	percent := dark * 100 / (size * size)
+	penalty += 10 * (abs(percent-50) / 5)
+	return penalty
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
@@ -0,0 +1,468 @@
+package certificate
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"rsc.io/qr/coding"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// longestText returns the longest byte mode text that fits version at level M
+func longestText(version int) string {
+	n := (8*qrBlocksM[version].dataLength() - 4 - countBits(version)) / 8
+	var b strings.Builder
+	for i := 0; i < n; i++ {
+		b.WriteByte("abcdefghijklmnopqrstuvwxyz0123456789/:?=&"[i%41])
+	}
+	return b.String()
+}
+
+// encodeWithMask is EncodeQR with the version and mask chosen by the caller
+func encodeWithMask(text string, version, mask int) *QRCode {
+	qr := newQRCode(version)
+	qr.drawFunctionPatterns(version)
+	qr.drawCodewords(addErrorCorrection(version, dataCodewords(version, []byte(text))))
+	qr.applyMask(mask)
+	qr.drawFormatBits(mask)
+	return qr
+}
+
+// reference encodes text with rsc.io/qr/coding, an independent implementation
+func reference(t *testing.T, text string, version, mask int) *coding.Code {
+	t.Helper()
+	plan, err := coding.NewPlan(coding.Version(version), coding.M, coding.Mask(mask))
+	if err != nil {
+		t.Fatalf("reference plan for version %d mask %d: %v", version, mask, err)
+	}
+	code, err := plan.Encode(coding.String(text))
+	if err != nil {
+		t.Fatalf("reference encode for version %d mask %d: %v", version, mask, err)
+	}
+	return code
+}
+
+func sameModules(qr *QRCode, code *coding.Code) bool {
+	if qr.Size != code.Size {
+		return false
+	}
+	for y := 0; y < qr.Size; y++ {
+		for x := 0; x < qr.Size; x++ {
+			if qr.Modules[y][x] != code.Black(x, y) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Every version and mask must match the reference module for module, which covers the
+// block tables, Reed-Solomon codewords, interleaving, placement and format and version bits
+func TestEncodeQRMatchesReference(t *testing.T) {
+	for version := 1; version <= 20; version++ {
+		for _, text := range []string{"A", longestText(version)} {
+			if version > 1 && len(text) == 1 {
+				continue
+			}
+			for mask := 0; mask < 8; mask++ {
+				if !sameModules(encodeWithMask(text, version, mask), reference(t, text, version, mask)) {
+					t.Errorf("version %d mask %d, %d bytes: modules differ from the reference", version, mask, len(text))
+				}
+			}
+		}
+	}
+}
+
+func TestEncodeQRVersionAndMask(t *testing.T) {
+	for version := 1; version <= 20; version++ {
+		text := longestText(version)
+		qr, err := EncodeQR(text)
+		if err != nil {
+			t.Fatalf("EncodeQR(%d bytes): %v", len(text), err)
+		}
+		if want := 4*version + 17; qr.Size != want {
+			t.Errorf("EncodeQR(%d bytes) has size %d, want %d for version %d", len(text), qr.Size, want, version)
+		}
+		matched := false
+		for mask := 0; mask < 8 && !matched; mask++ {
+			matched = sameModules(qr, reference(t, text, version, mask))
+		}
+		if !matched {
+			t.Errorf("EncodeQR(%d bytes) matches no reference mask of version %d", len(text), version)
+		}
+
+		// One byte more needs the next version
+		if version < 20 {
+			if qr, err := EncodeQR(text + "x"); err != nil || qr.Size != 4*version+21 {
+				t.Errorf("EncodeQR(%d bytes) should move to version %d", len(text)+1, version+1)
+			}
+		}
+	}
+	if _, err := EncodeQR(longestText(20) + "x"); err == nil {
+		t.Error("EncodeQR accepted text longer than version 20 holds")
+	}
+}
+
+func TestEncodeQRDecodes(t *testing.T) {
+	all := make([]byte, 256)
+	for i := range all {
+		all[i] = byte(i)
+	}
+	texts := []string{
+		"",
+		"https://quantumscholar.example/verify/3f9a1c2b7d",
+		"https://quantumscholar.example/verify/3f9a1c2b7d?token=eyJpZCI6IjNmOWExYzJiN2QiLCJoIjoiQW5hIEzDs3BleiIsInQiOiJRdWFudHVtIE1lY2hhbmljcyAxMDEiLCJzIjo4Ny41LCJtIjoxMDAsInAiOnRydWUsImkiOjE3NjA3NzQ0MDB9.c2lnbmF0dXJl",
+		"Zoë Ødegård — 名前",
+		string(all[:200]),
+		longestText(9),
+		longestText(10),
+		longestText(20),
+	}
+	for _, text := range texts {
+		qr, err := EncodeQR(text)
+		if err != nil {
+			t.Fatalf("EncodeQR(%q): %v", text, err)
+		}
+		got, err := decodeQR(qr.Modules)
+		if err != nil {
+			t.Errorf("decoding EncodeQR(%q): %v", text, err)
+			continue
+		}
+		if got != text {
+			t.Errorf("EncodeQR(%q) decodes to %q", text, got)
+		}
+	}
+}
+
+func TestEncodeQRGolden(t *testing.T) {
+	qr, err := EncodeQR("https://quantumscholar.example/verify/3f9a1c2b7d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	for _, row := range qr.Modules {
+		for _, dark := range row {
+			if dark {
+				b.WriteByte('#')
+			} else {
+				b.WriteByte('.')
+			}
+		}
+		b.WriteByte('\n')
+	}
+
+	path := filepath.Join("testdata", "verify_url.qr.golden")
+	if *update {
+		if err := os.WriteFile(path, b.Bytes(), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b.Bytes(), want) {
+		t.Errorf("QR code differs from %s, run with -update if the change is intended:\n%s", path, b.String())
+	}
+}
+
+// decodeQR reads a level M byte mode symbol back, checking the format bits and every
+// Reed-Solomon block along the way. It shares no code with the encoder but the block table,
+// which TestEncodeQRMatchesReference checks against the reference.
+func decodeQR(modules [][]bool) (string, error) {
+	size := len(modules)
+	version := (size - 17) / 4
+	if version < 1 || version > 20 || 4*version+17 != size {
+		return "", fmt.Errorf("size %d is not a QR version", size)
+	}
+	dark := func(x, y int) bool { return modules[y][x] }
+
+	// Format information, the copy around the top left finder and the split copy
+	var first, second int
+	for i, p := range [][2]int{{8, 0}, {8, 1}, {8, 2}, {8, 3}, {8, 4}, {8, 5}, {8, 7}, {8, 8}, {7, 8}, {5, 8}, {4, 8}, {3, 8}, {2, 8}, {1, 8}, {0, 8}} {
+		if dark(p[0], p[1]) {
+			first |= 1 << i
+		}
+	}
+	for i := 0; i < 15; i++ {
+		x, y := size-1-i, 8
+		if i >= 8 {
+			x, y = 8, size-15+i
+		}
+		if dark(x, y) {
+			second |= 1 << i
+		}
+	}
+	if first != second {
+		return "", fmt.Errorf("format copies differ: %015b and %015b", first, second)
+	}
+	if !dark(8, size-8) {
+		return "", fmt.Errorf("the dark module is light")
+	}
+	format := -1
+	for candidate := 0; candidate < 32; candidate++ {
+		if bchFormat(candidate) == first {
+			format = candidate
+		}
+	}
+	if format < 0 {
+		return "", fmt.Errorf("format bits %015b are not a valid codeword", first)
+	}
+	if format>>3 != 0 {
+		return "", fmt.Errorf("error correction level %02b, want M", format>>3)
+	}
+	mask := format & 7
+
+	// Version information, both copies
+	if version >= 7 {
+		for i := 0; i < 18; i++ {
+			want := (bchVersion(version)>>i)&1 == 1
+			if dark(size-11+i%3, i/3) != want || dark(i/3, size-11+i%3) != want {
+				return "", fmt.Errorf("version information bit %d is wrong", i)
+			}
+		}
+	}
+
+	reserved := functionModules(version)
+	var bits []bool
+	for right := size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right--
+		}
+		upward := ((size-1-right)/2)%2 == 0
+		if right < 6 {
+			upward = ((size-2-right)/2)%2 == 0
+		}
+		for i := 0; i < size; i++ {
+			y := i
+			if upward {
+				y = size - 1 - i
+			}
+			for _, x := range []int{right, right - 1} {
+				if reserved[y][x] {
+					continue
+				}
+				bits = append(bits, dark(x, y) != maskInverts(mask, x, y))
+			}
+		}
+	}
+
+	blocks := qrBlocksM[version]
+	count := blocks.group1Blocks + blocks.group2Blocks
+	total := blocks.dataLength() + count*blocks.ecPerBlock
+	if len(bits) < 8*total {
+		return "", fmt.Errorf("%d data modules, want at least %d", len(bits), 8*total)
+	}
+	codewords := make([]byte, total)
+	for i := range codewords {
+		for j := 0; j < 8; j++ {
+			if bits[8*i+j] {
+				codewords[i] |= 1 << (7 - j)
+			}
+		}
+	}
+
+	// De-interleave: data codewords column by column, the longer blocks last, then the
+	// error correction codewords
+	dataBlocks := make([][]byte, count)
+	ecBlocks := make([][]byte, count)
+	next := 0
+	for i := 0; i < max(blocks.group1DataLength, blocks.group2DataLength); i++ {
+		for b := 0; b < count; b++ {
+			length := blocks.group1DataLength
+			if b >= blocks.group1Blocks {
+				length = blocks.group2DataLength
+			}
+			if i < length {
+				dataBlocks[b] = append(dataBlocks[b], codewords[next])
+				next++
+			}
+		}
+	}
+	for i := 0; i < blocks.ecPerBlock; i++ {
+		for b := 0; b < count; b++ {
+			ecBlocks[b] = append(ecBlocks[b], codewords[next])
+			next++
+		}
+	}
+	var data []byte
+	for b := 0; b < count; b++ {
+		block := append(append([]byte{}, dataBlocks[b]...), ecBlocks[b]...)
+		for i := 0; i < blocks.ecPerBlock; i++ {
+			if s := evaluate(block, gfExp[i]); s != 0 {
+				return "", fmt.Errorf("block %d has syndrome %d = %d, the error correction is wrong", b, i, s)
+			}
+		}
+		data = append(data, dataBlocks[b]...)
+	}
+
+	// Byte mode segment
+	pos := 0
+	read := func(n int) int {
+		v := 0
+		for i := 0; i < n; i++ {
+			v = v<<1 | int(data[pos/8]>>(7-pos%8)&1)
+			pos++
+		}
+		return v
+	}
+	if mode := read(4); mode != 0b0100 {
+		return "", fmt.Errorf("mode %04b, want byte mode", mode)
+	}
+	length := read(countBitsForVersion(version))
+	if 4+countBitsForVersion(version)+8*length > 8*len(data) {
+		return "", fmt.Errorf("length %d overruns the data", length)
+	}
+	out := make([]byte, length)
+	for i := range out {
+		out[i] = byte(read(8))
+	}
+	if pos+4 <= 8*len(data) && read(4) != 0 {
+		return "", fmt.Errorf("missing terminator")
+	}
+	for i, pad := (pos+7)/8, byte(0xEC); i < len(data); i, pad = i+1, pad^0xEC^0x11 {
+		if data[i] != pad {
+			return "", fmt.Errorf("pad codeword %d is %#x, want %#x", i, data[i], pad)
+		}
+	}
+	return string(out), nil
+}
+
+func countBitsForVersion(version int) int {
+	if version < 10 {
+		return 8
+	}
+	return 16
+}
+
+// bchFormat is the masked BCH(15,5) codeword of 5 format bits
+func bchFormat(format int) int {
+	code := format << 10
+	for bit := 14; bit >= 10; bit-- {
+		if code>>bit&1 == 1 {
+			code ^= 0x537 << (bit - 10)
+		}
+	}
+	return (format<<10 | code) ^ 0x5412
+}
+
+// bchVersion is the BCH(18,6) codeword of a version number
+func bchVersion(version int) int {
+	code := version << 12
+	for bit := 17; bit >= 12; bit-- {
+		if code>>bit&1 == 1 {
+			code ^= 0x1F25 << (bit - 12)
+		}
+	}
+	return version<<12 | code
+}
+
+// functionModules marks the modules that carry no data: finders with their separators and
+// the format areas, timing patterns, alignment patterns and version information
+func functionModules(version int) [][]bool {
+	size := 4*version + 17
+	reserved := make([][]bool, size)
+	for y := range reserved {
+		reserved[y] = make([]bool, size)
+	}
+	mark := func(x0, y0, w, h int) {
+		for y := y0; y < y0+h; y++ {
+			for x := x0; x < x0+w; x++ {
+				reserved[y][x] = true
+			}
+		}
+	}
+	mark(0, 0, 9, 9)
+	mark(size-8, 0, 8, 9)
+	mark(0, size-8, 9, 8)
+	mark(6, 0, 1, size)
+	mark(0, 6, size, 1)
+	if version >= 7 {
+		mark(size-11, 0, 3, 6)
+		mark(0, size-11, 6, 3)
+	}
+	centres := alignmentCentres(version)
+	last := len(centres) - 1
+	for i, cy := range centres {
+		for j, cx := range centres {
+			if (i == 0 && j == 0) || (i == 0 && j == last) || (i == last && j == 0) {
+				continue // overlaps a finder
+			}
+			mark(cx-2, cy-2, 5, 5)
+		}
+	}
+	return reserved
+}
+
+// alignmentCentres works the alignment pattern positions out from the version, as the table
+// in the specification was built
+func alignmentCentres(version int) []int {
+	if version == 1 {
+		return nil
+	}
+	count := version/7 + 2
+	step := (version*4 + count*2 + 1) / (2*count - 2) * 2
+	centres := make([]int, count)
+	centres[0] = 6
+	for i, pos := count-1, 4*version+10; i >= 1; i, pos = i-1, pos-step {
+		centres[i] = pos
+	}
+	return centres
+}
+
+func maskInverts(mask, x, y int) bool {
+	switch mask {
+	case 0:
+		return (y+x)%2 == 0
+	case 1:
+		return y%2 == 0
+	case 2:
+		return x%3 == 0
+	case 3:
+		return (y+x)%3 == 0
+	case 4:
+		return (y/2+x/3)%2 == 0
+	case 5:
+		return (y*x)%2+(y*x)%3 == 0
+	case 6:
+		return ((y*x)%2+(y*x)%3)%2 == 0
+	default:
+		return ((y+x)%2+(y*x)%3)%2 == 0
+	}
+}
+
+// Exponent and logarithm tables of GF(2^8) with the QR polynomial, built independently of gfMultiply
+var gfExp, gfLog = func() ([256]byte, [256]byte) {
+	var exp, log [256]byte
+	v := 1
+	for i := 0; i < 255; i++ {
+		exp[i] = byte(v)
+		log[v] = byte(i)
+		v <<= 1
+		if v >= 256 {
+			v ^= 0x11D
+		}
+	}
+	exp[255] = exp[0]
+	return exp, log
+}()
+
+// evaluate returns the polynomial whose coefficients are block, highest degree first, at x
+func evaluate(block []byte, x byte) byte {
+	var result byte
+	for _, c := range block {
+		if result != 0 && x != 0 {
+			result = gfExp[(int(gfLog[result])+int(gfLog[x]))%255]
+		} else {
+			result = 0
+		}
+		result ^= c
+	}
+	return result
+}
@@ -0,0 +1,138 @@
+package content
+
+import (
+	"fmt"
+	"html"
+	"strings"
+)
+
+// Formats a text field of a question can be written in, plain is used when none is declared.
+// Math is written in LaTeX, between $...$ or \(...\) inline and $$...$$ on its own, and rendered
+// as <span class="math math-inline"> or <div class="math math-display"> holding the escaped
+// source for the frontend to typeset with KaTeX.
+const (
+	Plain    = "plain"
+	Markdown = "markdown"
+	HTML     = "html"
+	LaTeX    = "latex" // the whole field is one displayed formula
+)
+
+// MaxLength caps a single field, anything longer is not a question
+const MaxLength = 20000
+
+// ValidFormat reports whether format is one of the supported formats or empty
+func ValidFormat(format string) bool {
+	switch format {
+	case "", Plain, Markdown, HTML, LaTeX:
+		return true
+	}
+	return false
+}
+
+// Validate checks text against its declared format, the math it contains must be well formed
+func Validate(format, text string) error {
+	if !ValidFormat(format) {
+		return fmt.Errorf("unknown content format %q, use plain, markdown, html or latex", format)
+	}
+	if len(text) > MaxLength {
+		return fmt.Errorf("text is longer than %d characters", MaxLength)
+	}
+	switch format {
+	case LaTeX:
+		return validateTeX(text)
+	case Markdown:
+		_, err := renderMarkdown(text)
+		return err
+	case HTML:
+		return validateHTMLMath(text)
+	}
+	return nil
+}
+
+// Clean returns what should be stored for text, HTML is sanitised and everything else is kept as written
+func Clean(format, text string) (string, error) {
+	if err := Validate(format, text); err != nil {
+		return "", err
+	}
+	if format == HTML {
+		return Sanitize(text), nil
+	}
+	return text, nil
+}
+
+// RenderHTML turns text into HTML that is safe to insert into a page
+func RenderHTML(format, text string) string {
+	switch format {
+	case Markdown:
+		out, err := renderMarkdown(text)
+		if err != nil {
+			return "<p>" + html.EscapeString(text) + "</p>"
+		}
+		return Sanitize(out)
+	case HTML:
+		return Sanitize(text)
+	case LaTeX:
+		return mathHTML(text, true)
+	default:
+		return strings.ReplaceAll(html.EscapeString(text), "\n", "<br>")
+	}
+}
+
+// mathHTML wraps LaTeX source for the frontend's math renderer
+func mathHTML(tex string, display bool) string {
+	if display {
+		return `<div class="math math-display">` + html.EscapeString(strings.TrimSpace(tex)) + `</div>`
+	}
+	return `<span class="math math-inline">` + html.EscapeString(strings.TrimSpace(tex)) + `</span>`
+}
+
+// TeX commands that load resources, run code or change the renderer, KaTeX refuses most of
+// them when untrusted but they are rejected here too
+var forbiddenTeXCommands = map[string]bool{
+	`\href`: true, `\url`: true, `\includegraphics`: true, `\htmlClass`: true, `\htmlId`: true,
+	`\htmlStyle`: true, `\htmlData`: true, `\def`: true, `\gdef`: true, `\edef`: true, `\xdef`: true,
+	`\let`: true, `\newcommand`: true, `\renewcommand`: true, `\providecommand`: true,
+	`\input`: true, `\include`: true, `\write`: true, `\immediate`: true, `\openout`: true, `\catcode`: true,
+}
+
+// validateTeX checks that braces balance and that no forbidden command is used
+func validateTeX(tex string) error {
+	if strings.TrimSpace(tex) == "" {
+		return fmt.Errorf("formula is empty")
+	}
+	depth := 0
+	for i := 0; i < len(tex); i++ {
+		switch tex[i] {
+		case '\\':
+			// A control word runs to the first non-letter, so \url must not match \urlfoo.
+			// Anything else is a control symbol, \{ is a literal brace.
+			end := i + 1
+			for end < len(tex) && isLetter(tex[end]) {
+				end++
+			}
+			if end == i+1 {
+				i++
+				continue
+			}
+			if command := tex[i:end]; forbiddenTeXCommands[command] {
+				return fmt.Errorf("formula uses %s, which is not allowed", command)
+			}
+			i = end - 1
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth < 0 {
+				return fmt.Errorf("formula has an unmatched }")
+			}
+		}
+	}
+	if depth != 0 {
+		return fmt.Errorf("formula has an unmatched {")
+	}
+	return nil
+}
+
+func isLetter(b byte) bool {
+	return (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z')
+}
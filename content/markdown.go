@@ -0,0 +1,242 @@
+package content
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// renderMarkdown renders the subset of Markdown questions need: paragraphs with line breaks,
+// headings, bullet and numbered lists, block quotes, fenced code, bold, italic, strikethrough,
+// inline code, links and math. Raw HTML is shown as text, never passed through.
+func renderMarkdown(text string) (string, error) {
+	text = strings.ReplaceAll(strings.ReplaceAll(text, "\r\n", "\n"), "\x00", "")
+	lines := strings.Split(text, "\n")
+
+	var b strings.Builder
+	var paragraph []string
+	var listTag string
+	flushParagraph := func() error {
+		if len(paragraph) == 0 {
+			return nil
+		}
+		rendered, err := renderInline(strings.Join(paragraph, "\n"))
+		if err != nil {
+			return err
+		}
+		b.WriteString("<p>" + strings.ReplaceAll(rendered, "\n", "<br>") + "</p>")
+		paragraph = nil
+		return nil
+	}
+	closeList := func() {
+		if listTag != "" {
+			b.WriteString("</" + listTag + ">")
+			listTag = ""
+		}
+	}
+
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+
+		// Blocks that span several lines
+		if strings.HasPrefix(trimmed, "```") || trimmed == "$$" {
+			if err := flushParagraph(); err != nil {
+				return "", err
+			}
+			closeList()
+			fence := "```"
+			if trimmed == "$$" {
+				fence = "$$"
+			}
+			var body []string
+			closed := false
+			for i++; i < len(lines); i++ {
+				if strings.TrimSpace(lines[i]) == fence {
+					closed = true
+					break
+				}
+				body = append(body, lines[i])
+			}
+			if fence == "$$" {
+				if !closed {
+					return "", fmt.Errorf("display math opened with $$ is never closed")
+				}
+				tex := strings.Join(body, "\n")
+				if err := validateTeX(tex); err != nil {
+					return "", err
+				}
+				b.WriteString(mathHTML(tex, true))
+			} else {
+				b.WriteString("<pre><code>" + html.EscapeString(strings.Join(body, "\n")) + "</code></pre>")
+			}
+			continue
+		}
+
+		if trimmed == "" {
+			if err := flushParagraph(); err != nil {
+				return "", err
+			}
+			closeList()
+			continue
+		}
+
+		if level, heading := headingLevel(trimmed); level > 0 {
+			if err := flushParagraph(); err != nil {
+				return "", err
+			}
+			closeList()
+			rendered, err := renderInline(heading)
+			if err != nil {
+				return "", err
+			}
+			fmt.Fprintf(&b, "<h%d>%s</h%d>", level, rendered, level)
+			continue
+		}
+
+		if tag, item, ok := listItem(trimmed); ok {
+			if err := flushParagraph(); err != nil {
+				return "", err
+			}
+			if listTag != tag {
+				closeList()
+				b.WriteString("<" + tag + ">")
+				listTag = tag
+			}
+			rendered, err := renderInline(item)
+			if err != nil {
+				return "", err
+			}
+			b.WriteString("<li>" + rendered + "</li>")
+			continue
+		}
+
+		if strings.HasPrefix(trimmed, ">") {
+			if err := flushParagraph(); err != nil {
+				return "", err
+			}
+			closeList()
+			rendered, err := renderInline(strings.TrimSpace(strings.TrimPrefix(trimmed, ">")))
+			if err != nil {
+				return "", err
+			}
+			b.WriteString("<blockquote>" + rendered + "</blockquote>")
+			continue
+		}
+
+		closeList()
+		paragraph = append(paragraph, trimmed)
+	}
+	if err := flushParagraph(); err != nil {
+		return "", err
+	}
+	closeList()
+	return b.String(), nil
+}
+
+func headingLevel(line string) (int, string) {
+	level := 0
+	for level < len(line) && level < 6 && line[level] == '#' {
+		level++
+	}
+	if level == 0 || level >= len(line) || line[level] != ' ' {
+		return 0, ""
+	}
+	return level, strings.TrimSpace(line[level:])
+}
+
+var orderedItem = regexp.MustCompile(`^(\d{1,9})[.)] (.*)$`)
+
+func listItem(line string) (string, string, bool) {
+	if strings.HasPrefix(line, "- ") || strings.HasPrefix(line, "* ") || strings.HasPrefix(line, "+ ") {
+		return "ul", strings.TrimSpace(line[2:]), true
+	}
+	if m := orderedItem.FindStringSubmatch(line); m != nil {
+		return "ol", strings.TrimSpace(m[2]), true
+	}
+	return "", "", false
+}
+
+var (
+	codeSpan      = regexp.MustCompile("`([^`]+)`")
+	displayMath   = regexp.MustCompile(`\$\$(.+?)\$\$`)
+	parenMath     = regexp.MustCompile(`\\\((.+?)\\\)`)
+	inlineMath    = regexp.MustCompile(`\$([^\s$](?:[^$]*[^\s$])?)\$`)
+	link          = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)\)`)
+	bold          = regexp.MustCompile(`\*\*(.+?)\*\*`)
+	italic        = regexp.MustCompile(`\*([^*\s](?:[^*]*[^*\s])?)\*`)
+	strikethrough = regexp.MustCompile(`~~(.+?)~~`)
+	placeholder   = regexp.MustCompile("\x00(\\d+)\x00")
+)
+
+// renderInline renders the spans of one block. Code and math are cut out first so that
+// nothing inside them is read as Markdown, the rest is escaped before any markup is added.
+// A span cut out later can hold the placeholder of one cut out earlier, as in `\$`, so its
+// source is restored before it is rendered and saved fragments never hold placeholders.
+func renderInline(text string) (string, error) {
+	var saved, sources []string
+	save := func(fragment, source string) string {
+		saved = append(saved, fragment)
+		sources = append(sources, source)
+		return "\x00" + strconv.Itoa(len(saved)-1) + "\x00"
+	}
+	restore := func(s string) string {
+		return placeholder.ReplaceAllStringFunc(s, func(p string) string {
+			i, _ := strconv.Atoi(placeholder.FindStringSubmatch(p)[1])
+			return sources[i]
+		})
+	}
+	var mathErr error
+	saveMath := func(display bool) func(string, []string) string {
+		return func(whole string, groups []string) string {
+			tex := restore(groups[1])
+			if err := validateTeX(tex); err != nil && mathErr == nil {
+				mathErr = err
+			}
+			return save(mathHTML(tex, display), restore(whole))
+		}
+	}
+
+	text = strings.ReplaceAll(text, `\$`, save("$", `\$`))
+	text = replaceSubmatch(codeSpan, text, func(whole string, g []string) string {
+		return save("<code>"+html.EscapeString(restore(g[1]))+"</code>", restore(whole))
+	})
+	text = replaceSubmatch(displayMath, text, saveMath(true))
+	text = replaceSubmatch(parenMath, text, saveMath(false))
+	text = replaceSubmatch(inlineMath, text, saveMath(false))
+	if mathErr != nil {
+		return "", mathErr
+	}
+
+	text = html.EscapeString(text)
+	text = replaceSubmatch(link, text, func(whole string, g []string) string {
+		href := html.UnescapeString(restore(g[2]))
+		if !safeURL(href) {
+			return whole
+		}
+		return `<a href="` + html.EscapeString(href) + `">` + g[1] + `</a>`
+	})
+	text = replaceSubmatch(bold, text, func(_ string, g []string) string {
+		return "<strong>" + g[1] + "</strong>"
+	})
+	text = replaceSubmatch(italic, text, func(_ string, g []string) string {
+		return "<em>" + g[1] + "</em>"
+	})
+	text = replaceSubmatch(strikethrough, text, func(_ string, g []string) string {
+		return "<del>" + g[1] + "</del>"
+	})
+
+	return placeholder.ReplaceAllStringFunc(text, func(p string) string {
+		i, _ := strconv.Atoi(placeholder.FindStringSubmatch(p)[1])
+		return saved[i]
+	}), nil
+}
+
+// replaceSubmatch is ReplaceAllStringFunc with access to the groups of each match
+func replaceSubmatch(re *regexp.Regexp, text string, f func(string, []string) string) string {
+	return re.ReplaceAllStringFunc(text, func(match string) string {
+		return f(match, re.FindStringSubmatch(match))
+	})
+}
@@ -0,0 +1,109 @@
+package content
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderMarkdown(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"paragraph", "hello\nworld", "<p>hello<br>world</p>"},
+		{"heading", "## Title", "<h2>Title</h2>"},
+		{"list", "- a\n- b", "<ul><li>a</li><li>b</li></ul>"},
+		{"numbered list", "1. a\n2. b", "<ol><li>a</li><li>b</li></ol>"},
+		{"emphasis", "**b** *i* ~~s~~", "<p><strong>b</strong> <em>i</em> <del>s</del></p>"},
+		{"code span", "`a*b*c`", "<p><code>a*b*c</code></p>"},
+		{"fenced code", "```\n<b>x</b>\n```", "<pre><code>&lt;b&gt;x&lt;/b&gt;</code></pre>"},
+		{"inline math", "$x^2$", `<p><span class="math math-inline">x^2</span></p>`},
+		{"paren math", `\(a<b\)`, `<p><span class="math math-inline">a&lt;b</span></p>`},
+		{"display math", "$$\n\\frac{1}{2}\n$$", `<div class="math math-display">\frac{1}{2}</div>`},
+		{"escaped dollar", `costs \$5`, "<p>costs $5</p>"},
+		{"escaped dollar in code span", "`\\$x`", `<p><code>\$x</code></p>`},
+		{"escaped dollar in math", `$\$5 + x$`, `<p><span class="math math-inline">\$5 + x</span></p>`},
+		{"code span in link text", "[`x`](https://example.com)", `<p><a href="https://example.com"><code>x</code></a></p>`},
+		{"math in bold", "**$x$**", `<p><strong><span class="math math-inline">x</span></strong></p>`},
+		{"raw html shown as text", "<script>alert(1)</script>", "<p>&lt;script&gt;alert(1)&lt;/script&gt;</p>"},
+		{"raw html in code span", "`<script>`", "<p><code>&lt;script&gt;</code></p>"},
+		{"html in math", "$<img src=x onerror=alert(1)>$", `<p><span class="math math-inline">&lt;img src=x onerror=alert(1)&gt;</span></p>`},
+		{"safe link", "[x](https://example.com/?a=1&b=2)", `<p><a href="https://example.com/?a=1&amp;b=2">x</a></p>`},
+		{"javascript link", "[x](javascript:alert(1))", "<p>[x](javascript:alert(1))</p>"},
+		{"entity encoded link", "[x](jav&#x61;script:alert(1))", "<p>[x](jav&amp;#x61;script:alert(1))</p>"},
+		{"link with quote", `[x](https://example.com/"onmouseover=alert(1))`, `<p><a href="https://example.com/&#34;onmouseover=alert(1">x</a>)</p>`},
+		{"nul bytes dropped", "a\x000\x00b", "<p>a0b</p>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := renderMarkdown(tt.input)
+			if err != nil {
+				t.Fatalf("renderMarkdown(%q) failed: %v", tt.input, err)
+			}
+			if got != tt.want {
+				t.Errorf("renderMarkdown(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderMarkdownRejectsMath(t *testing.T) {
+	inputs := []string{
+		`$\href{https://example.com}{x}$`,
+		`\(\url{https://example.com}\)`,
+		"$$\n\\def\\x{1}\n$$",
+		"$$\nx\n",
+		`$\frac{1}{2$`,
+		"`code` and $\\input{x}$",
+	}
+	for _, input := range inputs {
+		if _, err := renderMarkdown(input); err == nil {
+			t.Errorf("renderMarkdown(%q) succeeded, want an error", input)
+		}
+	}
+}
+
+// No placeholder may leak into the output, however spans nest
+func TestRenderMarkdownNoPlaceholders(t *testing.T) {
+	inputs := []string{
+		"`\\$`",
+		"`$x$`",
+		"$`x`$",
+		"[`\\$`](https://example.com)",
+		"**`\\$` $\\$$**",
+		"\\$`\\$`\\$",
+	}
+	for _, input := range inputs {
+		got, err := renderMarkdown(input)
+		if err != nil {
+			t.Fatalf("renderMarkdown(%q) failed: %v", input, err)
+		}
+		if strings.Contains(got, "\x00") {
+			t.Errorf("renderMarkdown(%q) = %q, holds a placeholder", input, got)
+		}
+	}
+}
+
+func TestValidateTeX(t *testing.T) {
+	tests := []struct {
+		tex     string
+		wantErr bool
+	}{
+		{`\frac{1}{2}`, false},
+		{`\{ x \}`, false},
+		{`\urlfoo`, false},
+		{`\\href`, false},
+		{`\url{x}`, true},
+		{`x + \href{a}{b}`, true},
+		{`\catcode`, true},
+		{`{`, true},
+		{`}{`, true},
+		{` `, true},
+	}
+	for _, tt := range tests {
+		if err := validateTeX(tt.tex); (err != nil) != tt.wantErr {
+			t.Errorf("validateTeX(%q) = %v, want error %v", tt.tex, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package content
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Elements kept by Sanitize, anything else is dropped but its text is kept
+var allowedElements = map[string]bool{
+	"p": true, "br": true, "hr": true, "div": true, "span": true,
+	"b": true, "strong": true, "i": true, "em": true, "u": true, "s": true, "del": true,
+	"sub": true, "sup": true, "small": true, "mark": true,
+	"code": true, "pre": true, "blockquote": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"ul": true, "ol": true, "li": true,
+	"table": true, "thead": true, "tbody": true, "tfoot": true, "tr": true, "th": true, "td": true, "caption": true,
+	"a": true,
+}
+
+// Elements dropped together with everything inside them
+var droppedElements = map[string]bool{
+	"script": true, "style": true, "iframe": true, "frame": true, "frameset": true, "object": true,
+	"embed": true, "applet": true, "template": true, "noscript": true, "textarea": true, "select": true,
+	"svg": true, "math": true, "head": true, "title": true, "base": true, "link": true, "meta": true,
+}
+
+var voidElements = map[string]bool{"br": true, "hr": true}
+
+// Only the classes the math renderer looks for may be set
+var allowedClasses = map[string]bool{"math math-inline": true, "math math-display": true}
+
+// Sanitize parses an HTML fragment and rebuilds it from an allow list of elements and
+// attributes. Scripts, event handlers, styles and links other than http, https and mailto
+// never survive.
+func Sanitize(fragment string) string {
+	context := &html.Node{Type: html.ElementNode, Data: "div", DataAtom: atom.Div}
+	nodes, err := html.ParseFragment(strings.NewReader(fragment), context)
+	if err != nil {
+		return html.EscapeString(fragment)
+	}
+	var b strings.Builder
+	for _, n := range nodes {
+		writeSanitized(&b, n)
+	}
+	return b.String()
+}
+
+// validateHTMLMath checks the TeX held by the math elements of an HTML fragment, the same as
+// math written in Markdown
+func validateHTMLMath(fragment string) error {
+	context := &html.Node{Type: html.ElementNode, Data: "div", DataAtom: atom.Div}
+	nodes, err := html.ParseFragment(strings.NewReader(fragment), context)
+	if err != nil {
+		// Sanitize escapes a fragment it cannot parse, nothing in it is typeset
+		return nil
+	}
+	var check func(n *html.Node) error
+	check = func(n *html.Node) error {
+		if n.Type == html.ElementNode && isMathElement(n) {
+			return validateTeX(textContent(n))
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			if err := check(child); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, n := range nodes {
+		if err := check(n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isMathElement reports whether Sanitize keeps n as a span or div for the math renderer
+func isMathElement(n *html.Node) bool {
+	tag := strings.ToLower(n.Data)
+	if tag != "span" && tag != "div" {
+		return false
+	}
+	for _, attr := range n.Attr {
+		if strings.ToLower(attr.Key) == "class" && allowedClasses[attr.Val] {
+			return true
+		}
+	}
+	return false
+}
+
+// textContent joins the text of every node under n, as the math renderer reads it
+func textContent(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+	var b strings.Builder
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		b.WriteString(textContent(child))
+	}
+	return b.String()
+}
+
+func writeSanitized(b *strings.Builder, n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		b.WriteString(html.EscapeString(n.Data))
+		return
+	case html.ElementNode:
+	default:
+		// Comments and doctypes are dropped
+		return
+	}
+
+	tag := strings.ToLower(n.Data)
+	if droppedElements[tag] {
+		return
+	}
+	if !allowedElements[tag] {
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			writeSanitized(b, child)
+		}
+		return
+	}
+
+	b.WriteString("<" + tag)
+	for _, attr := range allowedAttributes(tag, n.Attr) {
+		b.WriteString(" " + attr.Key + `="` + html.EscapeString(attr.Val) + `"`)
+	}
+	b.WriteString(">")
+	if voidElements[tag] {
+		return
+	}
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		writeSanitized(b, child)
+	}
+	b.WriteString("</" + tag + ">")
+}
+
+// allowedAttributes keeps the few attributes each element needs
+func allowedAttributes(tag string, attrs []html.Attribute) []html.Attribute {
+	var out []html.Attribute
+	for _, attr := range attrs {
+		key := strings.ToLower(attr.Key)
+		switch {
+		case tag == "a" && key == "href":
+			if safeURL(attr.Val) {
+				out = append(out, html.Attribute{Key: "href", Val: attr.Val})
+			}
+		case tag == "a" && key == "title":
+			out = append(out, html.Attribute{Key: key, Val: attr.Val})
+		case (tag == "td" || tag == "th") && (key == "colspan" || key == "rowspan"):
+			if isSmallNumber(attr.Val) {
+				out = append(out, html.Attribute{Key: key, Val: attr.Val})
+			}
+		case tag == "ol" && key == "start":
+			if isSmallNumber(attr.Val) {
+				out = append(out, html.Attribute{Key: key, Val: attr.Val})
+			}
+		case (tag == "span" || tag == "div") && key == "class":
+			if allowedClasses[attr.Val] {
+				out = append(out, html.Attribute{Key: key, Val: attr.Val})
+			}
+		}
+	}
+	if tag == "a" {
+		out = append(out, html.Attribute{Key: "rel", Val: "noopener noreferrer nofollow"}, html.Attribute{Key: "target", Val: "_blank"})
+	}
+	return out
+}
+
+// safeURL accepts absolute http, https and mailto links only
+func safeURL(raw string) bool {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return false
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https":
+		return u.Host != ""
+	case "mailto":
+		return u.Opaque != ""
+	}
+	return false
+}
+
+func isSmallNumber(s string) bool {
+	v, err := strconv.Atoi(s)
+	return err == nil && v >= 0 && v <= 1000
+}
@@ -0,0 +1,130 @@
+package content
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSanitize(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"plain text", "a < b & c", "a &lt; b &amp; c"},
+		{"allowed markup", "<p><b>bold</b> <em>em</em></p>", "<p><b>bold</b> <em>em</em></p>"},
+		{"script dropped with its text", "x<script>alert(1)</script>y", "xy"},
+		{"unclosed script", "x<script>alert(1)", "x"},
+		{"event handler", `<p onclick="alert(1)">hi</p>`, "<p>hi</p>"},
+		{"img with onerror", `<img src=x onerror=alert(1)>text`, "text"},
+		{"unclosed img", `text<img src=x onerror=alert(1)`, "text"},
+		{"svg", `<svg onload=alert(1)><circle r="1"></circle></svg>after`, "after"},
+		{"svg script", `<svg><script>alert(1)</script></svg>`, ""},
+		{"math", `<math><mtext><a href="javascript:alert(1)">x</a></mtext></math>`, ""},
+		{"style attribute", `<span style="background:url(javascript:alert(1))">s</span>`, "<span>s</span>"},
+		{"iframe", `<iframe src="https://example.com"></iframe>ok`, "ok"},
+		{"javascript href", `<a href="javascript:alert(1)">x</a>`, `<a rel="noopener noreferrer nofollow" target="_blank">x</a>`},
+		{"javascript href in capitals", `<a href="JaVaScRiPt:alert(1)">x</a>`, `<a rel="noopener noreferrer nofollow" target="_blank">x</a>`},
+		{"javascript href behind whitespace", `<a href=" &#14; javascript:alert(1)">x</a>`, `<a rel="noopener noreferrer nofollow" target="_blank">x</a>`},
+		{"entity encoded scheme", `<a href="jav&#x61;script:alert(1)">x</a>`, `<a rel="noopener noreferrer nofollow" target="_blank">x</a>`},
+		{"entity encoded colon", `<a href="javascript&colon;alert(1)">x</a>`, `<a rel="noopener noreferrer nofollow" target="_blank">x</a>`},
+		{"tab inside scheme", "<a href=\"java\tscript:alert(1)\">x</a>", `<a rel="noopener noreferrer nofollow" target="_blank">x</a>`},
+		{"data href", `<a href="data:text/html,<script>alert(1)</script>">x</a>`, `<a rel="noopener noreferrer nofollow" target="_blank">x</a>`},
+		{"relative href", `<a href="//evil.example/x">x</a>`, `<a rel="noopener noreferrer nofollow" target="_blank">x</a>`},
+		{"https href", `<a href="https://example.com/?a=1&b=2">x</a>`, `<a href="https://example.com/?a=1&amp;b=2" rel="noopener noreferrer nofollow" target="_blank">x</a>`},
+		{"mailto href", `<a href="mailto:a@example.com">x</a>`, `<a href="mailto:a@example.com" rel="noopener noreferrer nofollow" target="_blank">x</a>`},
+		{"unclosed tag", `<b>bold`, "<b>bold</b>"},
+		{"unclosed attribute", `<a href="https://example.com>x`, ""},
+		{"comment", `a<!-- <script>alert(1)</script> -->b`, "ab"},
+		{"unknown element keeps its text", `<blink>text</blink>`, "text"},
+		{"math class kept", `<span class="math math-inline">x^2</span>`, `<span class="math math-inline">x^2</span>`},
+		{"other class dropped", `<span class="math math-inline evil">x</span>`, "<span>x</span>"},
+		{"colspan number", `<table><tbody><tr><td colspan="2" onmouseover="x">c</td></tr></tbody></table>`, `<table><tbody><tr><td colspan="2">c</td></tr></tbody></table>`},
+		{"colspan not a number", `<table><tbody><tr><td colspan="2;x">c</td></tr></tbody></table>`, `<table><tbody><tr><td>c</td></tr></tbody></table>`},
+		{"attribute breaking out", `<a title='"><script>alert(1)</script>'>x</a>`, `<a title="&#34;&gt;&lt;script&gt;alert(1)&lt;/script&gt;" rel="noopener noreferrer nofollow" target="_blank">x</a>`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Sanitize(tt.input); got != tt.want {
+				t.Errorf("Sanitize(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+// Whatever the input, nothing that can run script may come out
+func TestSanitizeNeverEmitsScript(t *testing.T) {
+	inputs := []string{
+		`<scr<script>ipt>alert(1)</script>`,
+		`<<script>script>alert(1)<</script>/script>`,
+		`<a href="javascript:alert(1)" onclick="alert(1)">x`,
+		`<div><svg><foreignObject><img src=x onerror=alert(1)></foreignObject></svg></div>`,
+		`<noscript><p title="</noscript><img src=x onerror=alert(1)>"></noscript>`,
+		`<template><img src=x onerror=alert(1)></template>`,
+		`<textarea><img src=x onerror=alert(1)></textarea>`,
+		`<object data="javascript:alert(1)"></object>`,
+		`<base href="javascript:/">`,
+		`<meta http-equiv="refresh" content="0;url=javascript:alert(1)">`,
+		`<form action="javascript:alert(1)"><button>x</button></form>`,
+		`<p>unclosed <a href="https://example.com" onmouseover=alert(1) x`,
+	}
+	for _, input := range inputs {
+		got := strings.ToLower(Sanitize(input))
+		for _, bad := range []string{"<script", "<img", "<svg", "<iframe", "<object", "<form", "<base", "<meta", "javascript:", " on"} {
+			if strings.Contains(got, bad) {
+				t.Errorf("Sanitize(%q) = %q, contains %q", input, got, bad)
+			}
+		}
+	}
+}
+
+func TestSafeURL(t *testing.T) {
+	tests := []struct {
+		url  string
+		want bool
+	}{
+		{"https://example.com", true},
+		{"http://example.com/a?b=c", true},
+		{"mailto:a@example.com", true},
+		{"javascript:alert(1)", false},
+		{"JAVASCRIPT:alert(1)", false},
+		{" javascript:alert(1)", false},
+		{"vbscript:msgbox(1)", false},
+		{"data:text/html;base64,PHNjcmlwdD4=", false},
+		{"https:/example.com", false},
+		{"//example.com", false},
+		{"/relative", false},
+		{"mailto:", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := safeURL(tt.url); got != tt.want {
+			t.Errorf("safeURL(%q) = %v, want %v", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestValidateHTMLMath(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		wantErr bool
+	}{
+		{"no math", `<p>\href{x}{y}</p>`, false},
+		{"valid math", `<p>Area <span class="math math-inline">\pi r^2</span></p>`, false},
+		{"forbidden command inline", `<span class="math math-inline">\href{https://example.com}{x}</span>`, true},
+		{"forbidden command display", `<div class="math math-display">\def\x{1}</div>`, true},
+		{"forbidden command split by markup", `<span class="math math-inline">\hr<b>ef</b>{x}{y}</span>`, true},
+		{"unbalanced braces", `<span class="math math-inline">\frac{1}{2</span>`, true},
+		{"empty math", `<span class="math math-inline"> </span>`, true},
+		{"other class not checked", `<span class="note">\href{x}{y}</span>`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate(HTML, tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate(html, %q) = %v, want error %v", tt.input, err, tt.wantErr)
+			}
+		})
+	}
+}
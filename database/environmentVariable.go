@@ -50,6 +50,15 @@ var (
 	RZP_KEY_ID         string
 	RZP_KEY_SECRET     string
 	RZP_WEBHOOK_SECRET string
+
+	// Certificates
+	CERTIFICATE_SIGNING_KEY string
+
+	// AI grader for open-ended answers (optional)
+	AI_GRADER_URL                 string
+	AI_GRADER_API_KEY             string
+	AI_GRADER_MIN_CONFIDENCE      string
+	AI_GRADER_KEYWORD_SUGGESTIONS string
 )
 
 // LoadEnvVariables loads all required environment variables into global variables
@@ -109,4 +118,15 @@ func LoadEnvVariables() {
 	RZP_KEY_ID = getEnv("RZP_KEY_ID")
 	RZP_KEY_SECRET = getEnv("RZP_KEY_SECRET")
 	RZP_WEBHOOK_SECRET = getEnv("RZP_WEBHOOK_SECRET")
+
+	// Certificates, base64 of a 32 byte Ed25519 seed (openssl rand -base64 32). Optional, no
+	// credentials or certificates are issued when unset.
+	CERTIFICATE_SIGNING_KEY = os.Getenv("CERTIFICATE_SIGNING_KEY")
+
+	// AI grader for open-ended answers (optional, open-ended answers all go to human review when unset)
+	AI_GRADER_URL = os.Getenv("AI_GRADER_URL")
+	AI_GRADER_API_KEY = os.Getenv("AI_GRADER_API_KEY")
+	AI_GRADER_MIN_CONFIDENCE = os.Getenv("AI_GRADER_MIN_CONFIDENCE")
+	// "true" suggests marks from the built-in keyword grader when no AI grader is set, never final
+	AI_GRADER_KEYWORD_SUGGESTIONS = os.Getenv("AI_GRADER_KEYWORD_SUGGESTIONS")
 }
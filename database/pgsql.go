@@ -35,6 +35,9 @@ func dropConflictingTables() {
 		&models.TestAssignedToUser{},
 		&models.PaymentTable{},
 		&models.AnswerAttempt{},
+		&models.Regrade{},
+		&models.Certificate{},
+		&models.BankQuestion{},
 	)
 	if err != nil {
 		log.Fatal("Failed to drop tables:", err)
@@ -51,6 +54,9 @@ func MigratePgsql() {
 		&models.TestAssignedToUser{},
 		&models.PaymentTable{},
 		&models.AnswerAttempt{},
+		&models.Regrade{},
+		&models.Certificate{},
+		&models.BankQuestion{},
 	)
 	if err != nil {
 		if GIN_MODE == "release" {
@@ -73,10 +79,70 @@ func MigratePgsql() {
 			&models.TestAssignedToUser{},
 			&models.PaymentTable{},
 			&models.AnswerAttempt{},
+			&models.Regrade{},
+			&models.Certificate{},
+			&models.BankQuestion{},
 		)
 		if err != nil {
 			log.Fatal("Failed to migrate database even after dropping tables:", err)
 		}
 	}
+	backfillPgsql()
 	log.Println("Database migration completed")
 }
+
+// backfillPgsql brings rows written before a column existed in line with it. AutoMigrate gives
+// existing rows the column default, which is not always right for them. Every update only
+// matches rows that still need it, so running them on each start is harmless.
+func backfillPgsql() {
+	// Attempts started before statuses were recorded got the default 'initialized'
+	result := DB.Model(&models.AnswerAttempt{}).
+		Where("status = ? AND start_time > ?", "initialized", time.Time{}).
+		Update("status", "in-progress")
+	if result.Error != nil {
+		log.Fatal("Failed to backfill attempt statuses:", result.Error)
+	}
+	if result.RowsAffected > 0 {
+		log.Printf("Backfilled %d started attempts as in-progress", result.RowsAffected)
+	}
+
+	// Tests activated before the lifecycle existed got the default 'draft'
+	result = DB.Model(&models.Test{}).
+		Where("status = ? AND test_active = ?", "draft", true).
+		Update("status", "published")
+	if result.Error != nil {
+		log.Fatal("Failed to backfill test statuses:", result.Error)
+	}
+	if result.RowsAffected > 0 {
+		log.Printf("Backfilled %d active tests as published", result.RowsAffected)
+	}
+
+	// Attempts submitted before grading moved to a worker were graded on submission, unless
+	// grading failed and left the empty evaluation they were created with
+	result = DB.Model(&models.AnswerAttempt{}).
+		Where("status = ? AND grading_status IS NULL", "submitted").
+		Update("grading_status", gorm.Expr("CASE WHEN evaluation_json = '{}'::jsonb THEN 'pending' ELSE 'graded' END"))
+	if result.Error != nil {
+		log.Fatal("Failed to backfill grading statuses:", result.Error)
+	}
+	if result.RowsAffected > 0 {
+		log.Printf("Backfilled the grading status of %d submitted attempts", result.RowsAffected)
+	}
+
+	// Credentials revoked before the public status was kept apart from the reason, those
+	// replaced by a reissue or re-grade were superseded
+	result = DB.Model(&models.Certificate{}).
+		Where("revoked = ? AND revocation_status IS NULL", true).
+		Update("revocation_status", gorm.Expr("CASE WHEN revocation_reason LIKE 'Replaced by a credential %' OR revocation_reason LIKE 'Score changed from % by a re-grade' THEN 'superseded' ELSE 'revoked' END"))
+	if result.Error != nil {
+		log.Fatal("Failed to backfill revocation statuses:", result.Error)
+	}
+
+	// The heartbeat column is added empty, the grading workers only claim attempts with one
+	result = DB.Model(&models.AnswerAttempt{}).
+		Where("grading_heartbeat IS NULL").
+		Update("grading_heartbeat", time.Time{})
+	if result.Error != nil {
+		log.Fatal("Failed to backfill grading heartbeats:", result.Error)
+	}
+}
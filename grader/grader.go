@@ -0,0 +1,97 @@
+package grader
+
+import (
+	"context"
+	"log"
+	"strconv"
+
+	"github.com/Qubitopia/quantum-scholar-backend/database"
+)
+
+// Criterion is one rubric line an answer is marked against
+type Criterion struct {
+	ID          string  `json:"id"`
+	Description string  `json:"description"`
+	Points      float64 `json:"points"`
+}
+
+// Request carries everything a grader needs to mark one open-ended answer
+type Request struct {
+	QuestionText    string      `json:"question_text"`
+	ModelAnswer     string      `json:"model_answer"`
+	Rubric          []Criterion `json:"rubric,omitempty"`
+	CandidateAnswer string      `json:"candidate_answer"`
+	MaxMarks        float64     `json:"max_marks"`
+}
+
+// CriterionScore is the points awarded for one rubric criterion
+type CriterionScore struct {
+	ID        string  `json:"id"`
+	Points    float64 `json:"points"`
+	MaxPoints float64 `json:"maxPoints"`
+	Comment   string  `json:"comment,omitempty"`
+}
+
+// Result is a grader's verdict, Confidence is between 0 and 1.
+// CriterionScores is filled when the request had a rubric.
+type Result struct {
+	Score           float64          `json:"score"`
+	Confidence      float64          `json:"confidence"`
+	Rationale       string           `json:"rationale"`
+	CriterionScores []CriterionScore `json:"criterion_scores,omitempty"`
+}
+
+// Grader scores open-ended answers
+type Grader interface {
+	Grade(ctx context.Context, req Request) (Result, error)
+}
+
+// OpenEndedGrader is the grader used for open-ended answers, results below MinConfidence go to human review
+var OpenEndedGrader Grader
+var MinConfidence = 0.8
+
+// InitGrader uses the HTTP grader when AI_GRADER_URL is set. Otherwise open-ended answers are
+// left for human review, with the keyword grader's marks as suggestions if AI_GRADER_KEYWORD_SUGGESTIONS is true.
+func InitGrader() {
+	switch {
+	case database.AI_GRADER_URL != "":
+		OpenEndedGrader = NewHTTPGrader(database.AI_GRADER_URL, database.AI_GRADER_API_KEY)
+		log.Println("Open-ended answers will be graded by", database.AI_GRADER_URL)
+	case database.AI_GRADER_KEYWORD_SUGGESTIONS == "true":
+		OpenEndedGrader = suggestionsOnly{KeywordGrader{}}
+		log.Println("Open-ended answers will be reviewed by hand with marks suggested by the built-in keyword grader")
+	default:
+		log.Println("Open-ended answers will be reviewed by hand")
+	}
+
+	if database.AI_GRADER_MIN_CONFIDENCE != "" {
+		v, err := strconv.ParseFloat(database.AI_GRADER_MIN_CONFIDENCE, 64)
+		if err != nil || v < 0 || v > 1 {
+			log.Fatal("AI_GRADER_MIN_CONFIDENCE must be a number between 0 and 1")
+		}
+		MinConfidence = v
+	}
+}
+
+// suggestionsOnly reports no confidence in a grader's results, so every answer goes to human
+// review with the score as a suggestion. Keyword overlap alone is not enough to give final marks.
+type suggestionsOnly struct {
+	Grader
+}
+
+func (s suggestionsOnly) Grade(ctx context.Context, req Request) (Result, error) {
+	result, err := s.Grader.Grade(ctx, req)
+	result.Confidence = 0
+	return result, err
+}
+
+// clampScore keeps a score within [0, maxMarks]
+func clampScore(score, maxMarks float64) float64 {
+	if score < 0 {
+		return 0
+	}
+	if score > maxMarks {
+		return maxMarks
+	}
+	return score
+}
@@ -0,0 +1,88 @@
+package grader
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// HTTPGrader posts the Request as JSON to an endpoint (an LLM or an adapter in front of one)
+// and expects a Result as JSON in return
+type HTTPGrader struct {
+	URL    string
+	APIKey string
+	Client *http.Client
+}
+
+func NewHTTPGrader(url, apiKey string) *HTTPGrader {
+	return &HTTPGrader{
+		URL:    url,
+		APIKey: apiKey,
+		Client: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (g *HTTPGrader) Grade(ctx context.Context, req Request) (Result, error) {
+	var result Result
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		return result, err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, g.URL, bytes.NewReader(body))
+	if err != nil {
+		return result, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if g.APIKey != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+g.APIKey)
+	}
+
+	resp, err := g.Client.Do(httpReq)
+	if err != nil {
+		return result, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return result, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return result, fmt.Errorf("grader returned %d: %s", resp.StatusCode, respBody)
+	}
+	if err := json.Unmarshal(respBody, &result); err != nil {
+		return result, fmt.Errorf("invalid grader response: %w", err)
+	}
+
+	// Never trust the remote grader to stay in range
+	if result.Confidence < 0 || result.Confidence > 1 {
+		result.Confidence = 0
+	}
+	if len(req.Rubric) > 0 {
+		// The score is the sum of the criteria, every criterion must be scored
+		pointsByID := map[string]float64{}
+		for _, cs := range result.CriterionScores {
+			pointsByID[cs.ID] = cs.Points
+		}
+		scores := make([]CriterionScore, 0, len(req.Rubric))
+		result.Score = 0
+		for _, criterion := range req.Rubric {
+			points, ok := pointsByID[criterion.ID]
+			if !ok {
+				return result, fmt.Errorf("grader did not score criterion %s", criterion.ID)
+			}
+			points = clampScore(points, criterion.Points)
+			scores = append(scores, CriterionScore{ID: criterion.ID, Points: points, MaxPoints: criterion.Points})
+			result.Score += points
+		}
+		result.CriterionScores = scores
+	}
+	result.Score = clampScore(result.Score, req.MaxMarks)
+	return result, nil
+}
@@ -0,0 +1,170 @@
+package grader
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// KeywordGrader is a deterministic grader that scores an answer by how many of the model
+// answer's key terms (and adjacent term pairs) it covers. It is confident only when the
+// overlap is clearly high or clearly low. InitGrader only uses it for suggestions.
+type KeywordGrader struct{}
+
+var stopwords = map[string]bool{
+	"the": true, "and": true, "for": true, "are": true, "but": true, "not": true, "you": true,
+	"all": true, "any": true, "can": true, "has": true, "have": true, "had": true, "was": true,
+	"were": true, "this": true, "that": true, "these": true, "those": true, "with": true,
+	"from": true, "into": true, "onto": true, "than": true, "then": true, "them": true,
+	"they": true, "their": true, "there": true, "which": true, "what": true, "when": true,
+	"where": true, "who": true, "whom": true, "why": true, "how": true, "its": true, "also": true,
+	"such": true, "other": true, "some": true, "each": true, "both": true, "between": true,
+	"about": true, "over": true, "under": true, "will": true, "would": true, "should": true,
+	"could": true, "may": true, "might": true, "must": true, "does": true, "did": true,
+	"been": true, "being": true, "because": true, "while": true, "very": true, "more": true,
+	"most": true, "only": true, "own": true, "same": true, "our": true, "your": true, "his": true,
+	"her": true, "she": true, "him": true,
+}
+
+func (KeywordGrader) Grade(ctx context.Context, req Request) (Result, error) {
+	answerTerms := keyTerms(req.CandidateAnswer)
+
+	// Without a rubric the whole model answer is the reference
+	if len(req.Rubric) == 0 {
+		o := termOverlap(keyTerms(req.ModelAnswer), answerTerms)
+		if o.total == 0 {
+			return Result{Confidence: 0, Rationale: "Model answer has no key terms to compare against"}, nil
+		}
+		return Result{
+			Score:      roundToHalf(o.score, req.MaxMarks),
+			Confidence: math.Abs(2*o.score - 1),
+			Rationale:  o.rationale("the model answer"),
+		}, nil
+	}
+
+	// With a rubric each criterion is scored against its own description,
+	// the result is only as confident as the least confident criterion
+	result := Result{Confidence: 1}
+	var rationales []string
+	for _, criterion := range req.Rubric {
+		o := termOverlap(keyTerms(criterion.Description), answerTerms)
+		points := roundToHalf(o.score, criterion.Points)
+		result.CriterionScores = append(result.CriterionScores, CriterionScore{
+			ID:        criterion.ID,
+			Points:    points,
+			MaxPoints: criterion.Points,
+		})
+		result.Score += points
+		result.Confidence = math.Min(result.Confidence, math.Abs(2*o.score-1))
+		rationales = append(rationales, criterion.ID+": "+o.rationale("the criterion"))
+	}
+	result.Score = clampScore(result.Score, req.MaxMarks)
+	result.Rationale = strings.Join(rationales, "; ")
+	return result, nil
+}
+
+type overlap struct {
+	score   float64 // 0 to 1
+	matched int
+	total   int
+	missing []string
+}
+
+// termOverlap measures how much of the reference's key terms (and adjacent term pairs) the answer covers
+func termOverlap(referenceTerms, answerTerms []string) overlap {
+	var o overlap
+
+	// Unigram recall of the reference's key terms
+	answerSet := map[string]bool{}
+	for _, t := range answerTerms {
+		answerSet[t] = true
+	}
+	referenceSet := map[string]bool{}
+	for _, t := range referenceTerms {
+		if referenceSet[t] {
+			continue
+		}
+		referenceSet[t] = true
+		if answerSet[t] {
+			o.matched++
+		} else {
+			o.missing = append(o.missing, t)
+		}
+	}
+	o.total = len(referenceSet)
+	if o.total == 0 {
+		return o
+	}
+	unigramRecall := float64(o.matched) / float64(o.total)
+
+	// Bigram recall rewards keeping related terms together
+	answerBigrams := bigrams(answerTerms)
+	referenceBigrams := bigrams(referenceTerms)
+	bigramRecall := unigramRecall
+	if len(referenceBigrams) > 0 {
+		hits := 0
+		for b := range referenceBigrams {
+			if answerBigrams[b] {
+				hits++
+			}
+		}
+		bigramRecall = float64(hits) / float64(len(referenceBigrams))
+	}
+
+	o.score = 0.7*unigramRecall + 0.3*bigramRecall
+	return o
+}
+
+func (o overlap) rationale(reference string) string {
+	missing := append([]string(nil), o.missing...)
+	sort.Strings(missing)
+	if len(missing) > 5 {
+		missing = missing[:5]
+	}
+	rationale := fmt.Sprintf("Matched %d of %d key terms from %s", o.matched, o.total, reference)
+	if len(missing) > 0 {
+		rationale += " (missing: " + strings.Join(missing, ", ") + ")"
+	}
+	return rationale
+}
+
+// roundToHalf scales a 0 to 1 score to maxMarks, rounded to the nearest half mark
+func roundToHalf(score, maxMarks float64) float64 {
+	return clampScore(math.Round(score*maxMarks*2)/2, maxMarks)
+}
+
+// keyTerms lowercases, splits on anything that is not a letter or digit, drops stopwords
+// and short words, and strips common English suffixes
+func keyTerms(text string) []string {
+	fields := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	terms := make([]string, 0, len(fields))
+	for _, f := range fields {
+		if len([]rune(f)) < 3 || stopwords[f] {
+			continue
+		}
+		terms = append(terms, stem(f))
+	}
+	return terms
+}
+
+func stem(word string) string {
+	for _, suffix := range []string{"ing", "ed", "es", "s"} {
+		if strings.HasSuffix(word, suffix) && len(word)-len(suffix) >= 3 {
+			return strings.TrimSuffix(word, suffix)
+		}
+	}
+	return word
+}
+
+func bigrams(terms []string) map[string]bool {
+	set := map[string]bool{}
+	for i := 0; i+1 < len(terms); i++ {
+		set[terms[i]+" "+terms[i+1]] = true
+	}
+	return set
+}
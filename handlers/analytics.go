@@ -0,0 +1,250 @@
+package handlers
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"sort"
+
+	"github.com/Qubitopia/quantum-scholar-backend/database"
+	"github.com/Qubitopia/quantum-scholar-backend/models"
+	"github.com/gin-gonic/gin"
+)
+
+// Share of candidates in each of the top and bottom groups for the discrimination index
+const discriminationGroupShare = 0.27
+
+type OptionStatistics struct {
+	Option  int     `json:"option"`
+	Text    string  `json:"text"`
+	Correct bool    `json:"correct"`
+	Count   int     `json:"count"`
+	Percent float64 `json:"percent"`
+}
+
+type QuestionStatistics struct {
+	SectionID      int     `json:"section_id"`
+	QuestionNumber int     `json:"question_number"`
+	Type           string  `json:"type"`
+	QuestionText   string  `json:"question_text"`
+	MaxMarks       float64 `json:"max_marks"`
+	Attempts       int     `json:"attempts"` // candidates the question was presented to
+	Answered       int     `json:"answered"`
+	PendingReview  int     `json:"pending_review"` // left out of the mark based figures below
+	PercentCorrect float64 `json:"percent_correct"`
+	AverageMarks   float64 `json:"average_marks"`
+	// Average share of the marks scored, 1 is easy and 0 is hard
+	DifficultyIndex float64 `json:"difficulty_index"`
+	// Difficulty in the top 27% of candidates by total marks minus in the bottom 27%,
+	// missing when either group never saw the question
+	DiscriminationIndex *float64           `json:"discrimination_index"`
+	Options             []OptionStatistics `json:"options,omitempty"`
+	Flags               []string           `json:"flags,omitempty"`
+}
+
+// questionTally accumulates the graded responses to one question
+type questionTally struct {
+	attempts, answered, pending, correct int
+	graded                               int // responses with final marks
+	marks, fraction                      float64
+	topFraction, bottomFraction          float64
+	topCount, bottomCount                int
+	optionCounts                         map[int]int
+}
+
+// GetTestAnalytics computes per-question item analysis over the submitted attempts of a test
+func GetTestAnalytics(c *gin.Context) {
+	test, ok := getOwnedTest(c)
+	if !ok {
+		return
+	}
+
+	var tf TestFormat
+	if err := json.Unmarshal([]byte(test.QuestionAnswerJSON), &tf); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid question and answer format"})
+		return
+	}
+
+	var attempts []models.AnswerAttempt
+	if err := database.DB.Where("test_id = ? AND status = ? AND grading_status = ?", test.TestID, "submitted", "graded").Find(&attempts).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch attempts"})
+		return
+	}
+
+	// Rank candidates by total marks to find the top and bottom groups
+	sort.SliceStable(attempts, func(i, j int) bool {
+		return attempts[i].AchievedMarks > attempts[j].AchievedMarks
+	})
+	groupSize := int(math.Round(float64(len(attempts)) * discriminationGroupShare))
+	if groupSize == 0 && len(attempts) >= 2 {
+		groupSize = 1
+	}
+
+	tallies := map[int]map[int]*questionTally{}
+	for _, sec := range tf.Sections {
+		tallies[sec.SectionID] = map[int]*questionTally{}
+		for _, q := range sec.Questions {
+			tallies[sec.SectionID][q.QuestionNumber] = &questionTally{optionCounts: map[int]int{}}
+		}
+	}
+
+	totalMarks := 0.0
+	for rank, attempt := range attempts {
+		totalMarks += attempt.AchievedMarks
+		var evaluation Evaluation
+		if err := json.Unmarshal([]byte(attempt.EvaluationJSON), &evaluation); err != nil {
+			continue
+		}
+		answerByQuestion, err := indexAnswers(attempt.AnswerJSON, attempt.ShuffleJSON)
+		if err != nil {
+			continue
+		}
+		inTop := rank < groupSize
+		inBottom := rank >= len(attempts)-groupSize
+
+		for _, sec := range evaluation.Sections {
+			for _, qEval := range sec.Questions {
+				t := tallies[sec.SectionID][qEval.QuestionNumber]
+				if t == nil {
+					continue
+				}
+				t.attempts++
+				if qEval.Status != "unanswered" {
+					t.answered++
+				}
+				if a := answerByQuestion[sec.SectionID][qEval.QuestionNumber]; a != nil {
+					if a.CorrectOption != nil {
+						t.optionCounts[*a.CorrectOption]++
+					}
+					for _, o := range a.CorrectOptions {
+						t.optionCounts[o]++
+					}
+				}
+				if qEval.Status == "pending" {
+					t.pending++
+					continue
+				}
+				if qEval.Status == "correct" {
+					t.correct++
+				}
+				fraction := 0.0
+				if qEval.MaxMarks > 0 {
+					fraction = math.Max(qEval.Marks, 0) / qEval.MaxMarks
+				}
+				t.graded++
+				t.marks += qEval.Marks
+				t.fraction += fraction
+				if inTop {
+					t.topCount++
+					t.topFraction += fraction
+				}
+				if inBottom {
+					t.bottomCount++
+					t.bottomFraction += fraction
+				}
+			}
+		}
+	}
+
+	questions := []QuestionStatistics{}
+	for _, sec := range tf.Sections {
+		for _, q := range sec.Questions {
+			t := tallies[sec.SectionID][q.QuestionNumber]
+			stats := QuestionStatistics{
+				SectionID:      sec.SectionID,
+				QuestionNumber: q.QuestionNumber,
+				Type:           q.Type,
+				QuestionText:   q.QuestionText,
+				MaxMarks:       float64(q.SuccessMarks),
+				Attempts:       t.attempts,
+				Answered:       t.answered,
+				PendingReview:  t.pending,
+			}
+			if t.graded > 0 {
+				stats.PercentCorrect = roundTo(100*float64(t.correct)/float64(t.graded), 2)
+				stats.AverageMarks = roundTo(t.marks/float64(t.graded), 2)
+				stats.DifficultyIndex = roundTo(t.fraction/float64(t.graded), 3)
+			}
+			if t.topCount > 0 && t.bottomCount > 0 {
+				d := roundTo(t.topFraction/float64(t.topCount)-t.bottomFraction/float64(t.bottomCount), 3)
+				stats.DiscriminationIndex = &d
+			}
+
+			// Options are numbered from 1
+			if q.Type == "mcq" || q.Type == "msq" {
+				correct := map[int]bool{q.CorrectOption: true}
+				for _, o := range q.CorrectOptions {
+					correct[o] = true
+				}
+				for i, text := range q.Options {
+					option := OptionStatistics{
+						Option:  i + 1,
+						Text:    text,
+						Correct: correct[i+1],
+						Count:   t.optionCounts[i+1],
+					}
+					if t.answered > 0 {
+						option.Percent = roundTo(100*float64(option.Count)/float64(t.answered), 2)
+					}
+					stats.Options = append(stats.Options, option)
+				}
+			}
+
+			stats.Flags = itemFlags(stats)
+			questions = append(questions, stats)
+		}
+	}
+
+	averageMarks := 0.0
+	if len(attempts) > 0 {
+		averageMarks = roundTo(totalMarks/float64(len(attempts)), 2)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"test_id":             test.TestID,
+		"attempts":            len(attempts),
+		"average_marks":       averageMarks,
+		"discrimination_size": groupSize,
+		"questions":           questions,
+	})
+}
+
+// itemFlags points out questions that are likely too easy, too hard, ambiguous or keyed wrongly
+func itemFlags(stats QuestionStatistics) []string {
+	var flags []string
+	if stats.Attempts-stats.PendingReview < 5 {
+		return append(flags, "too-few-responses")
+	}
+	if stats.DifficultyIndex < 0.2 {
+		flags = append(flags, "very-hard")
+	}
+	if stats.DifficultyIndex > 0.9 {
+		flags = append(flags, "very-easy")
+	}
+	if d := stats.DiscriminationIndex; d != nil {
+		if *d < 0 {
+			// Weaker candidates doing better usually means the answer key is wrong
+			flags = append(flags, "negative-discrimination")
+		} else if *d < 0.2 {
+			flags = append(flags, "low-discrimination")
+		}
+	}
+	// A wrong option picked more often than every correct one suggests an ambiguous question
+	mostPickedCorrect, mostPickedWrong := 0, 0
+	for _, o := range stats.Options {
+		if o.Correct {
+			mostPickedCorrect = max(mostPickedCorrect, o.Count)
+		} else {
+			mostPickedWrong = max(mostPickedWrong, o.Count)
+		}
+	}
+	if len(stats.Options) > 0 && mostPickedWrong > mostPickedCorrect {
+		flags = append(flags, "distractor-preferred")
+	}
+	return flags
+}
+
+func roundTo(v float64, places int) float64 {
+	p := math.Pow(10, float64(places))
+	return math.Round(v*p) / p
+}
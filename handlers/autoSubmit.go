@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/Qubitopia/quantum-scholar-backend/database"
+	"github.com/Qubitopia/quantum-scholar-backend/models"
+)
+
+const autoSubmitInterval = time.Minute
+
+// StartAutoSubmitWorker periodically submits in-progress attempts whose time window
+// (duration + 5 min grace) has closed, and marks never-started attempts of ended tests
+// as abandoned. Scheduled results whose release time has passed are released in the
+// same sweep. Only one replica runs a sweep per interval, guarded by a Redis lock.
+func StartAutoSubmitWorker() {
+	ticker := time.NewTicker(autoSubmitInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		// The lock expires on its own just before the next tick, so a crashed replica never holds it
+		acquired, err := database.RedisClient.SetNX(context.Background(), "lock:auto-submit", "1", autoSubmitInterval-5*time.Second).Result()
+		if err != nil {
+			log.Println("Auto-submit: failed to acquire lock:", err)
+			continue
+		}
+		if !acquired {
+			continue
+		}
+		autoSubmitExpiredAttempts()
+	}
+}
+
+func autoSubmitExpiredAttempts() {
+	now := time.Now()
+
+	// 1) Submit started attempts whose window has closed, the deadline is worked out as in
+	// attemptDeadline and only the ids are loaded, not the answers
+	var expired []models.AnswerAttempt
+	if err := database.DB.Model(&models.AnswerAttempt{}).
+		Select("answer_id").
+		Where("status = ? AND COALESCE(NULLIF(end_time, ?), start_time + duration * interval '1 minute') + interval '5 minutes' < ?",
+			"in-progress", time.Time{}, now).
+		Find(&expired).Error; err != nil {
+		log.Println("Auto-submit: failed to fetch expired attempts:", err)
+		return
+	}
+
+	submitted := 0
+	for i := range expired {
+		if err := finaliseAttempt(&expired[i], true); err != nil {
+			if err != errAttemptAlreadyClosed {
+				log.Printf("Auto-submit: failed to submit attempt %d: %v", expired[i].AnswerID, err)
+			}
+			continue
+		}
+		submitted++
+	}
+
+	// 2) Attempts that were initialised but never started before the test closed are abandoned
+	endedTests := database.DB.Model(&models.Test{}).Select("test_id").Where("test_end_time < ?", now)
+	result := database.DB.Model(&models.AnswerAttempt{}).
+		Where("status = ? AND test_id IN (?)", "initialized", endedTests).
+		Update("status", "abandoned")
+	if result.Error != nil {
+		log.Println("Auto-submit: failed to mark abandoned attempts:", result.Error)
+	}
+
+	if submitted > 0 || result.RowsAffected > 0 {
+		log.Printf("Auto-submit: submitted %d attempts, marked %d attempts as abandoned", submitted, result.RowsAffected)
+	}
+
+	// 3) Scheduled results whose release time has passed
+	releaseScheduledResults()
+}
@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/Qubitopia/quantum-scholar-backend/models"
+)
+
+// BlueprintEntry asks for count questions of one topic and difficulty in every candidate's paper.
+// An empty difficulty matches questions that are not rated.
+type BlueprintEntry struct {
+	Topic      string `json:"topic"` // compared ignoring case
+	Difficulty string `json:"difficulty"`
+	Count      int    `json:"count"`
+}
+
+// questionPool is a group of a section's questions from which count are picked for every candidate
+type questionPool struct {
+	Name      string
+	Count     int   // zero picks them all
+	Questions []int // indices into the section's questions
+}
+
+// sectionPools splits the questions of a section into the pools they are picked from: one per
+// blueprint entry, or a single pool of questionsToDisplay without a blueprint, then one per bank
+// rule. Questions matching no blueprint entry are in no pool and never shown.
+func sectionPools(section Section) []questionPool {
+	var pools []questionPool
+	if len(section.Blueprint) > 0 {
+		for k, entry := range section.Blueprint {
+			pools = append(pools, questionPool{
+				Name:  fmt.Sprintf("blueprint entry %d (%s, %s)", k+1, entry.Topic, difficultyName(entry.Difficulty)),
+				Count: entry.Count,
+			})
+		}
+	} else {
+		pools = append(pools, questionPool{Name: "questionsToDisplay", Count: section.QuestionsToDisplay})
+	}
+	firstRule := len(pools)
+	for k, rule := range section.BankRules {
+		pools = append(pools, questionPool{Name: fmt.Sprintf("bank rule %d", k+1), Count: rule.Count})
+	}
+
+	for j, q := range section.Questions {
+		switch {
+		case q.BankRule > 0:
+			if q.BankRule <= len(section.BankRules) {
+				pools[firstRule+q.BankRule-1].Questions = append(pools[firstRule+q.BankRule-1].Questions, j)
+			}
+		case len(section.Blueprint) > 0:
+			if e := blueprintEntryFor(section.Blueprint, q); e >= 0 {
+				pools[e].Questions = append(pools[e].Questions, j)
+			}
+		default:
+			pools[0].Questions = append(pools[0].Questions, j)
+		}
+	}
+	return pools
+}
+
+// blueprintEntryFor returns the index of the blueprint entry a question belongs to, or -1
+func blueprintEntryFor(blueprint []BlueprintEntry, q Question) int {
+	for k, entry := range blueprint {
+		if sameTopic(entry.Topic, q.Topic) && entry.Difficulty == q.Difficulty {
+			return k
+		}
+	}
+	return -1
+}
+
+func sameTopic(a, b string) bool {
+	return strings.EqualFold(strings.TrimSpace(a), strings.TrimSpace(b))
+}
+
+func difficultyName(difficulty string) string {
+	if difficulty == "" {
+		return "unrated"
+	}
+	return difficulty
+}
+
+// validateBlueprint checks that the entries of a section's blueprint are distinct and add up to questionsToDisplay
+func validateBlueprint(section Section) error {
+	total := 0
+	for k, entry := range section.Blueprint {
+		if strings.TrimSpace(entry.Topic) == "" {
+			return fmt.Errorf("blueprint entry %d: topic is required", k+1)
+		}
+		if entry.Difficulty != "" && !difficultyLevels[entry.Difficulty] {
+			return fmt.Errorf("blueprint entry %d: difficulty must be easy, medium or hard", k+1)
+		}
+		if entry.Count < 1 {
+			return fmt.Errorf("blueprint entry %d: count must be at least 1", k+1)
+		}
+		for _, other := range section.Blueprint[:k] {
+			if sameTopic(entry.Topic, other.Topic) && entry.Difficulty == other.Difficulty {
+				return fmt.Errorf("blueprint entry %d: %s, %s is listed more than once", k+1, entry.Topic, difficultyName(entry.Difficulty))
+			}
+		}
+		total += entry.Count
+	}
+	if section.QuestionsToDisplay != total {
+		return fmt.Errorf("questionsToDisplay must be %d, the sum of the blueprint counts", total)
+	}
+	return nil
+}
+
+// validateSectionPools checks on publish that every pool has enough questions. With a blueprint,
+// and always for bank rules, all questions of a pool must also be worth the same marks, so every
+// paper has the same total and the same difficulty mix whichever questions are drawn.
+func validateSectionPools(section Section) error {
+	pools := sectionPools(section)
+	firstRule := len(pools) - len(section.BankRules)
+	for p, pool := range pools {
+		if pool.Count > len(pool.Questions) {
+			return fmt.Errorf("%s needs %d questions but only %d are available", pool.Name, pool.Count, len(pool.Questions))
+		}
+		if len(section.Blueprint) == 0 && p < firstRule {
+			continue
+		}
+		if err := validatePoolMarks(section, pool); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateBankRuleMarks checks that the questions copied in for each bank rule of a section are
+// worth the same marks, candidates are given a random count of them
+func validateBankRuleMarks(section Section) error {
+	pools := sectionPools(section)
+	for _, pool := range pools[len(pools)-len(section.BankRules):] {
+		if err := validatePoolMarks(section, pool); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validatePoolMarks(section Section, pool questionPool) error {
+	if len(pool.Questions) == 0 {
+		return nil
+	}
+	first := section.Questions[pool.Questions[0]]
+	for _, j := range pool.Questions[1:] {
+		q := section.Questions[j]
+		if q.SuccessMarks != first.SuccessMarks || q.FailureMarks != first.FailureMarks {
+			return fmt.Errorf("%s mixes questions worth different marks (question %d and question %d), every paper must have the same total", pool.Name, first.QuestionNumber, q.QuestionNumber)
+		}
+	}
+	return nil
+}
+
+// updatePoolStatistics records the size of the question pool and the number of distinct topics on the test
+func updatePoolStatistics(test *models.Test) {
+	var tf TestFormat
+	if err := json.Unmarshal([]byte(test.QuestionAnswerJSON), &tf); err != nil {
+		return
+	}
+	questions := 0
+	topics := map[string]bool{}
+	for _, section := range tf.Sections {
+		questions += len(section.Questions)
+		for _, q := range section.Questions {
+			if topic := strings.ToLower(strings.TrimSpace(q.Topic)); topic != "" {
+				topics[topic] = true
+			}
+		}
+	}
+	test.SizeOfQuestionPool = uint16(min(questions, math.MaxUint16))
+	test.NumberOfTopics = uint8(min(len(topics), math.MaxUint8))
+}
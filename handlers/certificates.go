@@ -0,0 +1,390 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/Qubitopia/quantum-scholar-backend/certificate"
+	"github.com/Qubitopia/quantum-scholar-backend/database"
+	"github.com/Qubitopia/quantum-scholar-backend/mail"
+	"github.com/Qubitopia/quantum-scholar-backend/models"
+	"github.com/gin-gonic/gin"
+)
+
+type CertificateSettingsRequest struct {
+	CertificatesEnabled bool    `json:"certificates_enabled"`
+	PassPercentage      float64 `json:"pass_percentage" binding:"gte=0,lte=100"`
+}
+
+// UpdateCertificateSettings turns certificates on or off for a test and sets the pass threshold
+func UpdateCertificateSettings(c *gin.Context) {
+	test, ok := getOwnedTest(c)
+	if !ok {
+		return
+	}
+
+	var req CertificateSettingsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	test.CertificatesEnabled = req.CertificatesEnabled
+	test.PassPercentage = req.PassPercentage
+	if err := database.DB.Save(&test).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update certificate settings"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Certificate settings updated successfully"})
+}
+
+// IssueCertificates issues a signed credential to every graded attempt that does not have one
+// yet, and a certificate PDF to those that passed, for example after pending answers have been
+// graded or the pass threshold was lowered. Credentials issued without a PDF are replaced when
+// the settings have changed what they should say.
+func IssueCertificates(c *gin.Context) {
+	test, ok := getOwnedTest(c)
+	if !ok {
+		return
+	}
+	if !certificate.Enabled() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Certificate signing is not configured"})
+		return
+	}
+	if !resultsReleased(test, time.Now()) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Certificates can only be issued once results are released"})
+		return
+	}
+
+	var attempts []models.AnswerAttempt
+	if err := database.DB.Where("test_id = ? AND status = ? AND grading_status = ?", test.TestID, "submitted", "graded").Find(&attempts).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch attempts"})
+		return
+	}
+
+	issued := issueCredentials(test, attempts)
+	c.JSON(http.StatusOK, gin.H{"message": "Certificates issued successfully", "issued": issued})
+}
+
+// GetCertificatesForTest lists the certificates issued for a test
+func GetCertificatesForTest(c *gin.Context) {
+	test, ok := getOwnedTest(c)
+	if !ok {
+		return
+	}
+
+	var certificates []models.Certificate
+	if err := database.DB.Where("test_id = ?", test.TestID).Order("issued_at").Find(&certificates).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch certificates"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"certificates": certificates})
+}
+
+// DownloadCertificate returns a short lived download link for a certificate PDF.
+// Public, the certificate id is the random value emailed to the candidate.
+func DownloadCertificate(c *gin.Context) {
+	var cert models.Certificate
+	if err := database.DB.Where("certificate_id = ?", c.Param("id")).First(&cert).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Certificate not found"})
+		return
+	}
+
+	if cert.Revoked {
+		c.JSON(http.StatusGone, gin.H{"error": "Certificate has been revoked"})
+		return
+	}
+	if cert.ObjectKey == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No certificate document was issued for this attempt"})
+		return
+	}
+
+	// Generate presigned URL valid for 15 minutes
+	url, err := database.GetPresignedURL(cert.ObjectKey, 15*time.Minute)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate URL: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"certificate_id": cert.CertificateID, "url": url})
+}
+
+// issueCredentials issues a signed credential for every fully graded attempt that has no
+// unrevoked one, and a certificate PDF that is emailed to the candidate when the attempt
+// reached the pass percentage of a test with certificates enabled. A credential issued
+// without a PDF is replaced when it no longer says whether the attempt passed or the attempt
+// now earns a PDF, e.g. after certificates were enabled or the pass percentage lowered.
+// Nothing is issued before results are released, or without a signing key.
+func issueCredentials(test models.Test, attempts []models.AnswerAttempt) int {
+	if !certificate.Enabled() || !resultsReleased(test, time.Now()) {
+		return 0
+	}
+
+	issued := 0
+	for _, attempt := range attempts {
+		if attempt.Status != "submitted" {
+			continue
+		}
+		var evaluation Evaluation
+		if err := json.Unmarshal([]byte(attempt.EvaluationJSON), &evaluation); err != nil {
+			continue
+		}
+		if evaluation.PendingReview > 0 || evaluation.MaxMarks <= 0 {
+			continue
+		}
+
+		var existing []models.Certificate
+		if err := database.DB.Where("attempt_id = ? AND revoked = ?", attempt.AnswerID, false).Find(&existing).Error; err != nil {
+			continue
+		}
+		if len(existing) > 0 {
+			// The signed record cannot change, a replacement gets a new id and the old one is revoked
+			passed := attemptPassed(test, attempt, evaluation)
+			if existing[0].ObjectKey != "" || (existing[0].Passed == passed && !(test.CertificatesEnabled && passed)) {
+				continue
+			}
+			reason := "Replaced by a credential with the current pass mark and certificate settings"
+			if err := revokeCredentials("certificate_id = ?", existing[0].CertificateID, test.ExaminerID, "superseded", reason); err != nil {
+				log.Printf("Certificates: failed to revoke credential %s for reissue: %v", existing[0].CertificateID, err)
+				continue
+			}
+		}
+
+		var candidate models.User
+		if err := database.DB.Where("id = ?", attempt.CandidateID).First(&candidate).Error; err != nil {
+			log.Printf("Certificates: candidate %d not found", attempt.CandidateID)
+			continue
+		}
+
+		if err := issueCredential(test, attempt, evaluation, candidate); err != nil {
+			log.Printf("Certificates: failed to issue credential for attempt %d: %v", attempt.AnswerID, err)
+			continue
+		}
+		issued++
+	}
+	return issued
+}
+
+func issueCredential(test models.Test, attempt models.AnswerAttempt, evaluation Evaluation, candidate models.User) error {
+	certificateID, err := newCertificateID()
+	if err != nil {
+		return err
+	}
+	cert := models.Certificate{
+		CertificateID: certificateID,
+		TestID:        test.TestID,
+		AttemptID:     attempt.AnswerID,
+		CandidateID:   candidate.ID,
+		HolderName:    candidate.Name,
+		TestName:      test.TestName,
+		Score:         attempt.AchievedMarks,
+		MaxScore:      evaluation.MaxMarks,
+		Passed:        attemptPassed(test, attempt, evaluation),
+		IssuedAt:      time.Now().UTC().Truncate(time.Second),
+	}
+	cert.Payload, cert.Signature, err = certificate.Sign(certificate.Credential{
+		ID:       cert.CertificateID,
+		Holder:   cert.HolderName,
+		Test:     cert.TestName,
+		Score:    cert.Score,
+		MaxScore: cert.MaxScore,
+		Passed:   cert.Passed,
+		IssuedAt: cert.IssuedAt.Unix(),
+	})
+	if err != nil {
+		return err
+	}
+	withDocument := test.CertificatesEnabled && cert.Passed
+	if withDocument {
+		cert.ObjectKey = fmt.Sprintf("certificates/%s.pdf", certificateID)
+	}
+
+	// The row is created first, the unique active attempt index stops a second replica issuing it again
+	if err := database.DB.Create(&cert).Error; err != nil {
+		return err
+	}
+	if !withDocument {
+		return nil
+	}
+
+	verifyURL := fmt.Sprintf("%s/verify/certificate/%s", database.BASE_URL, cert.CertificateID)
+	pdf, err := certificate.RenderPDF(certificate.Data{
+		CertificateID: cert.CertificateID,
+		HolderName:    cert.HolderName,
+		TestName:      cert.TestName,
+		Score:         cert.Score,
+		MaxScore:      cert.MaxScore,
+		IssuedAt:      cert.IssuedAt,
+		VerifyURL:     verifyURL,
+		QRContent:     verifyURL + "?token=" + certificate.Token(cert.Payload, cert.Signature),
+	})
+	if err == nil {
+		err = database.UploadObject(cert.ObjectKey, "application/pdf", pdf)
+	}
+	if err != nil {
+		database.DB.Delete(&cert)
+		return err
+	}
+
+	mail.SendEmailCertificate(
+		candidate.Email,
+		candidate.Name,
+		test.TestName,
+		fmt.Sprintf("%g", cert.Score),
+		fmt.Sprintf("%g", cert.MaxScore),
+		cert.CertificateID,
+		fmt.Sprintf("%s/certificate/%s", database.BASE_URL, cert.CertificateID),
+	)
+	return nil
+}
+
+// attemptPassed reports whether a fully graded attempt reached the test's pass percentage
+func attemptPassed(test models.Test, attempt models.AnswerAttempt, evaluation Evaluation) bool {
+	return 100*attempt.AchievedMarks/evaluation.MaxMarks >= test.PassPercentage
+}
+
+// newCertificateID returns 16 random base32 characters, easy to read out and type
+func newCertificateID() (string, error) {
+	b := make([]byte, 10)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base32.StdEncoding.EncodeToString(b), nil
+}
+
+type RevokeCertificateRequest struct {
+	Reason string `json:"reason" binding:"required"`
+}
+
+// VerifyCertificate lets anyone, typically an employer, check a credential. Pass ?token= from the
+// QR code to also check that the signed copy the holder presented matches the record.
+// Public, the response always shows what was recorded, never what the token claims.
+func VerifyCertificate(c *gin.Context) {
+	if !certificate.Enabled() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Certificate verification is not configured"})
+		return
+	}
+
+	var cert models.Certificate
+	if err := database.DB.Where("certificate_id = ?", c.Param("id")).First(&cert).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Certificate not found", "status": "not-found"})
+		return
+	}
+
+	status := "valid"
+	if !certificate.VerifySignature(cert.Payload, cert.Signature) {
+		status = "invalid"
+	} else if cert.Revoked {
+		// Why it was revoked stays internal, a reason can mention the candidate's scores
+		status = cert.RevocationStatus
+		if status == "" {
+			status = "revoked"
+		}
+	}
+
+	response := gin.H{
+		"certificate_id": cert.CertificateID,
+		"holder_name":    cert.HolderName,
+		"test_name":      cert.TestName,
+		"score":          cert.Score,
+		"max_score":      cert.MaxScore,
+		"passed":         cert.Passed,
+		"issued_at":      cert.IssuedAt,
+	}
+	if cert.Revoked {
+		response["revoked_at"] = cert.RevokedAt
+	}
+	if token := c.Query("token"); token != "" {
+		credential, payload, err := certificate.ParseToken(token)
+		tokenValid := err == nil && credential.ID == cert.CertificateID && payload == cert.Payload
+		response["token_valid"] = tokenValid
+		if !tokenValid && status == "valid" {
+			status = "invalid"
+		}
+	}
+	response["status"] = status
+
+	c.JSON(http.StatusOK, response)
+}
+
+// GetCertificatePublicKey returns the Ed25519 key that signs credentials, for offline verification
+func GetCertificatePublicKey(c *gin.Context) {
+	if !certificate.Enabled() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Certificate signing is not configured"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"algorithm":  "Ed25519",
+		"public_key": base64.StdEncoding.EncodeToString(certificate.PublicKey),
+	})
+}
+
+// RevokeCertificate withdraws a credential, only the examiner who owns the test can do this
+func RevokeCertificate(c *gin.Context) {
+	user, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found in context"})
+		return
+	}
+
+	examiner, ok := user.(models.User)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user from context"})
+		return
+	}
+
+	var req RevokeCertificateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var cert models.Certificate
+	if err := database.DB.Where("certificate_id = ?", c.Param("id")).First(&cert).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Certificate not found"})
+		return
+	}
+	var test models.Test
+	if err := database.DB.First(&test, cert.TestID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Test not found"})
+		return
+	}
+	if test.ExaminerID != examiner.ID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You are not the owner of this test"})
+		return
+	}
+	if cert.Revoked {
+		c.JSON(http.StatusConflict, gin.H{"error": "Certificate has already been revoked"})
+		return
+	}
+
+	if err := revokeCredentials("certificate_id = ?", cert.CertificateID, examiner.ID, "revoked", req.Reason); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke certificate"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Certificate revoked successfully"})
+}
+
+// revokeCredentials revokes the unrevoked credentials matching the condition. status is what
+// the public verify page shows, "revoked" or "superseded", reason is kept for the examiner.
+func revokeCredentials(condition string, value interface{}, examinerID uint32, status, reason string) error {
+	now := time.Now()
+	return database.DB.Model(&models.Certificate{}).
+		Where(condition+" AND revoked = ?", value, false).
+		Updates(map[string]interface{}{
+			"revoked":           true,
+			"revoked_at":        now,
+			"revoked_by":        examinerID,
+			"revocation_status": status,
+			"revocation_reason": reason,
+		}).Error
+}
@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/Qubitopia/quantum-scholar-backend/database"
+	"github.com/Qubitopia/quantum-scholar-backend/models"
+	"github.com/Qubitopia/quantum-scholar-backend/spreadsheet"
+	"github.com/gin-gonic/gin"
+)
+
+var unsafeFileNameChars = regexp.MustCompile(`[^A-Za-z0-9_-]+`)
+
+// ExportTestResults downloads one row per submitted attempt as CSV or XLSX (?format=csv|xlsx, csv by default)
+func ExportTestResults(c *gin.Context) {
+	test, ok := getOwnedTest(c)
+	if !ok {
+		return
+	}
+	format := c.DefaultQuery("format", "csv")
+	if format != "csv" && format != "xlsx" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be csv or xlsx"})
+		return
+	}
+
+	var tf TestFormat
+	if err := json.Unmarshal([]byte(test.QuestionAnswerJSON), &tf); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid question and answer format"})
+		return
+	}
+
+	var attempts []models.AnswerAttempt
+	if err := database.DB.Where("test_id = ? AND status = ? AND grading_status = ?", test.TestID, "submitted", "graded").Find(&attempts).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch attempts"})
+		return
+	}
+	sort.SliceStable(attempts, func(i, j int) bool {
+		if attempts[i].AchievedMarks != attempts[j].AchievedMarks {
+			return attempts[i].AchievedMarks > attempts[j].AchievedMarks
+		}
+		return attempts[i].AnswerID < attempts[j].AnswerID
+	})
+
+	candidateByID := map[uint32]models.User{}
+	if len(attempts) > 0 {
+		candidateIDs := make([]uint32, 0, len(attempts))
+		for _, a := range attempts {
+			candidateIDs = append(candidateIDs, a.CandidateID)
+		}
+		var candidates []models.User
+		if err := database.DB.Where("id IN ?", candidateIDs).Find(&candidates).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch candidates"})
+			return
+		}
+		for _, u := range candidates {
+			candidateByID[u.ID] = u
+		}
+	}
+
+	sheet := spreadsheet.Sheet{Name: test.TestName, Rows: [][]interface{}{resultsHeader(tf)}}
+	rank := 0
+	for i, attempt := range attempts {
+		// Equal totals share a rank, the next total skips the shared places (1, 2, 2, 4)
+		if i == 0 || attempt.AchievedMarks != attempts[i-1].AchievedMarks {
+			rank = i + 1
+		}
+		var evaluation Evaluation
+		if attempt.EvaluationJSON != "" {
+			if err := json.Unmarshal([]byte(attempt.EvaluationJSON), &evaluation); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Invalid evaluation for attempt %d", attempt.AnswerID)})
+				return
+			}
+		}
+		sheet.Rows = append(sheet.Rows, resultsRow(tf, attempt, evaluation, candidateByID[attempt.CandidateID], rank))
+	}
+
+	var buf bytes.Buffer
+	contentType := "text/csv"
+	var err error
+	if format == "xlsx" {
+		contentType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+		err = spreadsheet.WriteXLSX(&buf, sheet)
+	} else {
+		err = spreadsheet.WriteCSV(&buf, sheet)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate export"})
+		return
+	}
+
+	fileName := fmt.Sprintf("test-%d-%s-results.%s", test.TestID, unsafeFileNameChars.ReplaceAllString(test.TestName, "-"), format)
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fileName))
+	c.Data(http.StatusOK, contentType, buf.Bytes())
+}
+
+// resultsHeader lists the fixed columns, then one column per section and one per question
+func resultsHeader(tf TestFormat) []interface{} {
+	header := []interface{}{
+		"Rank", "Attempt ID", "Candidate Email", "Candidate Name",
+		"Start Time (UTC)", "Submit Time (UTC)", "Duration Used (min)", "Auto Submitted",
+	}
+	for _, sec := range tf.Sections {
+		header = append(header, fmt.Sprintf("Section %d: %s", sec.SectionID, sec.Title))
+	}
+	header = append(header, "Total Marks", "Max Marks", "Pending Review")
+	for _, sec := range tf.Sections {
+		for _, q := range sec.Questions {
+			header = append(header, fmt.Sprintf("S%d Q%d", sec.SectionID, q.QuestionNumber))
+		}
+	}
+	return header
+}
+
+// resultsRow fills the columns of resultsHeader for one attempt, questions not on the
+// candidate's paper are left empty
+func resultsRow(tf TestFormat, attempt models.AnswerAttempt, evaluation Evaluation, candidate models.User, rank int) []interface{} {
+	autoSubmitted := "no"
+	if attempt.AutoSubmitted {
+		autoSubmitted = "yes"
+	}
+	durationUsed := math.Round(attempt.SubmitTime.Sub(attempt.StartTime).Minutes()*100) / 100
+	row := []interface{}{
+		rank, int(attempt.AnswerID), candidate.Email, candidate.Name,
+		attempt.StartTime.UTC().Format(time.DateTime), attempt.SubmitTime.UTC().Format(time.DateTime),
+		durationUsed, autoSubmitted,
+	}
+
+	sectionByID := map[int]SectionEvaluation{}
+	marksByQuestion := map[int]map[int]float64{}
+	for _, sec := range evaluation.Sections {
+		sectionByID[sec.SectionID] = sec
+		marksByQuestion[sec.SectionID] = map[int]float64{}
+		for _, qEval := range sec.Questions {
+			marksByQuestion[sec.SectionID][qEval.QuestionNumber] = qEval.Marks
+		}
+	}
+
+	for _, sec := range tf.Sections {
+		if secEval, ok := sectionByID[sec.SectionID]; ok {
+			row = append(row, secEval.Marks)
+		} else {
+			row = append(row, nil)
+		}
+	}
+	row = append(row, attempt.AchievedMarks, evaluation.MaxMarks, evaluation.PendingReview)
+	for _, sec := range tf.Sections {
+		for _, q := range sec.Questions {
+			if marks, ok := marksByQuestion[sec.SectionID][q.QuestionNumber]; ok {
+				row = append(row, marks)
+			} else {
+				row = append(row, nil)
+			}
+		}
+	}
+	return row
+}
@@ -0,0 +1,603 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/Qubitopia/quantum-scholar-backend/database"
+	"github.com/Qubitopia/quantum-scholar-backend/grader"
+	"github.com/Qubitopia/quantum-scholar-backend/models"
+	"github.com/Qubitopia/quantum-scholar-backend/runner"
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Evaluation format stored in AnswerAttempt.EvaluationJSON
+type QuestionEvaluation struct {
+	QuestionNumber int      `json:"questionNumber"`
+	Type           string   `json:"type"`
+	Status         string   `json:"status"` // correct, partial, incorrect, unanswered, pending (needs manual review), ai-graded or graded (manually)
+	Marks          float64  `json:"marks"`
+	MaxMarks       float64  `json:"maxMarks"`
+	SuggestedMarks *float64 `json:"suggestedMarks,omitempty"` // grader's score when it was not confident enough to be final
+	Confidence     float64  `json:"confidence,omitempty"`
+	Rationale      string   `json:"rationale,omitempty"`
+	// Per-criterion scores for questions with a rubric, suggestions only while pending
+	CriterionScores []grader.CriterionScore `json:"criterionScores,omitempty"`
+	// Per test case outcome for code questions
+	TestCaseResults []runner.TestCaseResult `json:"testCaseResults,omitempty"`
+	Comment         string                  `json:"comment,omitempty"`
+	GradedBy        uint32                  `json:"gradedBy,omitempty"`
+	GradedAt        *time.Time              `json:"gradedAt,omitempty"`
+}
+
+type SectionEvaluation struct {
+	SectionID int                  `json:"sectionId"`
+	Marks     float64              `json:"marks"`
+	MaxMarks  float64              `json:"maxMarks"`
+	Questions []QuestionEvaluation `json:"questions"`
+}
+
+type Evaluation struct {
+	Sections      []SectionEvaluation `json:"sections"`
+	AchievedMarks float64             `json:"achievedMarks"`
+	MaxMarks      float64             `json:"maxMarks"`
+	PendingReview int                 `json:"pendingReview"`
+	GradedAt      time.Time           `json:"gradedAt"`
+}
+
+// candidatePaper is the minimal view of AnswerAttempt.QuestionJSON needed to know which questions were presented
+type candidatePaper struct {
+	Sections []struct {
+		SectionID int `json:"sectionId"`
+		Questions []struct {
+			QuestionNumber int `json:"questionNumber"`
+		} `json:"questions"`
+	} `json:"sections"`
+}
+
+// gradeAttempt scores the attempt's answers against the test's answer key and writes
+// the per-question breakdown to EvaluationJSON and the total to AchievedMarks.
+// The attempt is not saved, that is left to the caller.
+func gradeAttempt(test *models.Test, attempt *models.AnswerAttempt) error {
+	// 1) Answer key indexed by section id and question number
+	keyByQuestion, err := indexAnswerKey(test.QuestionAnswerJSON)
+	if err != nil {
+		return err
+	}
+
+	// 2) Questions presented to the candidate
+	var paper candidatePaper
+	if err := json.Unmarshal([]byte(attempt.QuestionJSON), &paper); err != nil {
+		return fmt.Errorf("invalid question set: %w", err)
+	}
+
+	// 3) Candidate answers indexed the same way
+	answerByQuestion, err := indexAnswers(attempt.AnswerJSON, attempt.ShuffleJSON)
+	if err != nil {
+		return err
+	}
+
+	// 4) Manual and AI grades from a previous evaluation are kept when re-grading
+	var previous Evaluation
+	if attempt.EvaluationJSON != "" {
+		if err := json.Unmarshal([]byte(attempt.EvaluationJSON), &previous); err != nil {
+			return fmt.Errorf("invalid evaluation: %w", err)
+		}
+	}
+	manualByQuestion := map[int]map[int]QuestionEvaluation{}
+	for _, sec := range previous.Sections {
+		for _, qEval := range sec.Questions {
+			if qEval.Status != "graded" && qEval.Status != "ai-graded" {
+				continue
+			}
+			if manualByQuestion[sec.SectionID] == nil {
+				manualByQuestion[sec.SectionID] = map[int]QuestionEvaluation{}
+			}
+			manualByQuestion[sec.SectionID][qEval.QuestionNumber] = qEval
+		}
+	}
+
+	// 5) Score every presented question, unanswered questions score zero
+	evaluation := Evaluation{GradedAt: time.Now()}
+	for _, sec := range paper.Sections {
+		secEval := SectionEvaluation{SectionID: sec.SectionID}
+		for _, pq := range sec.Questions {
+			q, ok := keyByQuestion[sec.SectionID][pq.QuestionNumber]
+			if !ok {
+				return fmt.Errorf("section %d, question %d: not found in answer key", sec.SectionID, pq.QuestionNumber)
+			}
+			a := answerByQuestion[sec.SectionID][pq.QuestionNumber]
+			qEval := scoreQuestion(q, a)
+			if qEval.Status == "pending" {
+				if manual, ok := manualByQuestion[sec.SectionID][pq.QuestionNumber]; ok {
+					qEval = manual
+				} else {
+					applyOpenEndedGrader(q, a, &qEval)
+				}
+			}
+			secEval.Questions = append(secEval.Questions, qEval)
+		}
+		evaluation.Sections = append(evaluation.Sections, secEval)
+	}
+	evaluation.recomputeTotals()
+
+	evaluationJSONBytes, err := json.Marshal(evaluation)
+	if err != nil {
+		return err
+	}
+	attempt.EvaluationJSON = string(evaluationJSONBytes)
+	attempt.AchievedMarks = evaluation.AchievedMarks
+	return nil
+}
+
+var errAttemptAlreadyClosed = errors.New("attempt has already been submitted")
+
+// indexAnswerKey parses Test.QuestionAnswerJSON into questions by section id and question number,
+// with the section scoring policy resolved onto each question
+func indexAnswerKey(questionAnswerJSON string) (map[int]map[int]Question, error) {
+	var key TestFormat
+	if err := json.Unmarshal([]byte(questionAnswerJSON), &key); err != nil {
+		return nil, fmt.Errorf("invalid answer key: %w", err)
+	}
+	keyByQuestion := map[int]map[int]Question{}
+	for _, sec := range key.Sections {
+		keyByQuestion[sec.SectionID] = map[int]Question{}
+		for _, q := range sec.Questions {
+			if q.ScoringPolicy == "" {
+				q.ScoringPolicy = sec.ScoringPolicy
+			}
+			keyByQuestion[sec.SectionID][q.QuestionNumber] = q
+		}
+	}
+	return keyByQuestion, nil
+}
+
+// indexAnswers parses AnswerAttempt.AnswerJSON into answers by section id and question number.
+// Answers to shuffled questions are mapped back to the answer key's numbering using ShuffleJSON.
+func indexAnswers(answerJSON string, shuffleJSON string) (map[int]map[int]*Answer, error) {
+	var answers AnswerPattern
+	if answerJSON != "" {
+		if err := json.Unmarshal([]byte(answerJSON), &answers); err != nil {
+			return nil, fmt.Errorf("invalid answers: %w", err)
+		}
+	}
+	shuffleByQuestion, err := indexShuffle(shuffleJSON)
+	if err != nil {
+		return nil, err
+	}
+	answerByQuestion := map[int]map[int]*Answer{}
+	for _, sec := range answers.Sections {
+		answerByQuestion[sec.SectionId] = map[int]*Answer{}
+		for i := range sec.Answers {
+			a := &sec.Answers[i]
+			if qs, ok := shuffleByQuestion[sec.SectionId][a.QuestionNumber]; ok {
+				unshuffleAnswer(a, qs)
+			}
+			answerByQuestion[sec.SectionId][a.QuestionNumber] = a
+		}
+	}
+	return answerByQuestion, nil
+}
+
+// applyOpenEndedGrader asks the configured grader to mark a pending open-ended answer. Confident
+// results become final marks, anything else stays pending with the grader's suggestion attached.
+func applyOpenEndedGrader(q Question, a *Answer, qEval *QuestionEvaluation) {
+	if grader.OpenEndedGrader == nil || q.Type != "open-ended" {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	result, err := grader.OpenEndedGrader.Grade(ctx, grader.Request{
+		QuestionText:    q.QuestionText,
+		ModelAnswer:     q.ModelAnswer,
+		Rubric:          q.Rubric,
+		CandidateAnswer: *a.Answer,
+		MaxMarks:        float64(q.SuccessMarks),
+	})
+	if err != nil {
+		log.Printf("Grader failed for question %d, leaving it for human review: %v", q.QuestionNumber, err)
+		return
+	}
+
+	qEval.Confidence = result.Confidence
+	qEval.Rationale = result.Rationale
+	qEval.CriterionScores = result.CriterionScores
+	if result.Confidence >= grader.MinConfidence {
+		qEval.Status = "ai-graded"
+		qEval.Marks = result.Score
+	} else {
+		qEval.SuggestedMarks = &result.Score
+	}
+}
+
+// recomputeTotals sums question marks into section and overall totals and counts responses awaiting review
+func (e *Evaluation) recomputeTotals() {
+	e.AchievedMarks, e.MaxMarks, e.PendingReview = 0, 0, 0
+	for i := range e.Sections {
+		sec := &e.Sections[i]
+		sec.Marks, sec.MaxMarks = 0, 0
+		for _, qEval := range sec.Questions {
+			if qEval.Status == "pending" {
+				e.PendingReview++
+			}
+			sec.Marks += qEval.Marks
+			sec.MaxMarks += qEval.MaxMarks
+		}
+		e.AchievedMarks += sec.Marks
+		e.MaxMarks += sec.MaxMarks
+	}
+}
+
+// finaliseAttempt submits an in-progress attempt and hands it to the grading worker.
+// The status change is conditional so that a candidate submission and the
+// auto-submit worker racing on different replicas cannot both finalise it.
+func finaliseAttempt(attempt *models.AnswerAttempt, autoSubmitted bool) error {
+	now := time.Now()
+	result := database.DB.Model(&models.AnswerAttempt{}).
+		Where("answer_id = ? AND status = ?", attempt.AnswerID, "in-progress").
+		Updates(submitUpdates(now, autoSubmitted))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errAttemptAlreadyClosed
+	}
+	attempt.Status = "submitted"
+	attempt.SubmitTime = now
+	attempt.AutoSubmitted = autoSubmitted
+	attempt.GradingStatus = "pending"
+
+	queueGrading(attempt.AnswerID)
+	return nil
+}
+
+// submitUpdates are the column changes that submit an in-progress attempt and leave it for grading
+func submitUpdates(now time.Time, autoSubmitted bool) map[string]interface{} {
+	return map[string]interface{}{
+		"status":         "submitted",
+		"submit_time":    now,
+		"auto_submitted": autoSubmitted,
+		"grading_status": "pending",
+	}
+}
+
+// scoreQuestion applies successMarks/failureMarks to a single answer, a nil answer means unanswered
+func scoreQuestion(q Question, a *Answer) QuestionEvaluation {
+	qEval := QuestionEvaluation{
+		QuestionNumber: q.QuestionNumber,
+		Type:           q.Type,
+		Status:         "unanswered",
+		MaxMarks:       float64(q.SuccessMarks),
+	}
+
+	switch q.Type {
+	case "mcq":
+		if a == nil || a.CorrectOption == nil {
+			return qEval
+		}
+		if *a.CorrectOption == q.CorrectOption {
+			qEval.Status = "correct"
+			qEval.Marks = float64(q.SuccessMarks)
+		} else {
+			qEval.Status = "incorrect"
+			qEval.Marks = float64(q.FailureMarks)
+		}
+	case "msq":
+		if a == nil || len(a.CorrectOptions) == 0 {
+			return qEval
+		}
+		qEval.Marks = scoreMSQ(q, a.CorrectOptions)
+		switch {
+		case qEval.Marks >= qEval.MaxMarks:
+			qEval.Status = "correct"
+		case qEval.Marks > 0:
+			qEval.Status = "partial"
+		default:
+			qEval.Status = "incorrect"
+		}
+	case "open-ended":
+		// Free-text answers cannot be scored automatically
+		if a == nil || a.Answer == nil || *a.Answer == "" {
+			return qEval
+		}
+		qEval.Status = "pending"
+	case "match":
+		if a == nil || !anyMatched(a.Matches) {
+			return qEval
+		}
+		correct := 0
+		for i, o := range q.CorrectMatches {
+			if i < len(a.Matches) && a.Matches[i] == o {
+				correct++
+			}
+		}
+		qEval.Marks, qEval.Status = scoreItems(q, correct, len(q.CorrectMatches))
+	case "order":
+		if a == nil || len(a.Order) == 0 {
+			return qEval
+		}
+		// Items are listed in the correct order in the answer key
+		correct := 0
+		for i, item := range a.Order {
+			if i < len(q.Items) && item == i+1 {
+				correct++
+			}
+		}
+		qEval.Marks, qEval.Status = scoreItems(q, correct, len(q.Items))
+	case "fill-blank":
+		if a == nil || !anyFilled(a.Blanks) {
+			return qEval
+		}
+		correct := 0
+		for i, blank := range q.Blanks {
+			if i < len(a.Blanks) && blankAccepted(blank, a.Blanks[i]) {
+				correct++
+			}
+		}
+		qEval.Marks, qEval.Status = scoreItems(q, correct, len(q.Blanks))
+	case "code":
+		if a == nil || a.Code == nil || strings.TrimSpace(*a.Code) == "" {
+			return qEval
+		}
+		runCodeTests(q, *a.Code, &qEval)
+	case "numeric":
+		if a == nil || a.NumericAnswer == nil {
+			return qEval
+		}
+		if numericAccepted(q, *a.NumericAnswer) {
+			qEval.Status = "correct"
+			qEval.Marks = float64(q.SuccessMarks)
+		} else {
+			qEval.Status = "incorrect"
+			qEval.Marks = float64(q.FailureMarks)
+		}
+	}
+	return qEval
+}
+
+// scoreItems scores a match, order or fill-blank question from the number of correct pairs, positions or blanks.
+// Without partialCredit anything short of all of them scores failureMarks.
+func scoreItems(q Question, correct, total int) (float64, string) {
+	switch {
+	case correct >= total:
+		return float64(q.SuccessMarks), "correct"
+	case q.PartialCredit && correct > 0:
+		return float64(q.SuccessMarks) * float64(correct) / float64(total), "partial"
+	default:
+		return float64(q.FailureMarks), "incorrect"
+	}
+}
+
+// anyMatched reports whether at least one item of a match answer was paired
+func anyMatched(matches []int) bool {
+	for _, o := range matches {
+		if o != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// maxCodeTestCases caps how many times one answer is run
+const maxCodeTestCases = 50
+
+// runCodeTests runs a code answer against the question's test cases and scores it by the share
+// that pass. When the code cannot be run at all the answer is left pending for manual grading.
+func runCodeTests(q Question, source string, qEval *QuestionEvaluation) {
+	limits := runner.Limits{
+		Timeout:     runner.DefaultTimeout,
+		MemoryBytes: runner.DefaultMemoryBytes,
+	}
+	if q.TimeLimitMs > 0 {
+		limits.Timeout = time.Duration(q.TimeLimitMs) * time.Millisecond
+	}
+	if q.MemoryLimitMb > 0 {
+		limits.MemoryBytes = int64(q.MemoryLimitMb) << 20
+	}
+
+	if runner.CodeRunner == nil || !runner.CodeRunner.Supports(q.Language) {
+		qEval.Status = "pending"
+		qEval.Rationale = fmt.Sprintf("No code runner for %s is available, grade manually", q.Language)
+		return
+	}
+	// Room for every test case to hit the time limit plus a slow compile
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(len(q.TestCases)+1)*(limits.Timeout+time.Second)+30*time.Second)
+	defer cancel()
+	result, err := runner.CodeRunner.Run(ctx, runner.Request{
+		Language:  q.Language,
+		Source:    source,
+		TestCases: q.TestCases,
+		Limits:    limits,
+	})
+	if err != nil {
+		log.Printf("Code runner: question %d: %v", q.QuestionNumber, err)
+		qEval.Status = "pending"
+		qEval.Rationale = "The code could not be run, grade manually"
+		return
+	}
+
+	qEval.TestCaseResults = result.TestCases
+	if result.CompileError != "" {
+		qEval.Rationale = result.CompileError
+	}
+	switch {
+	case result.Passed >= len(q.TestCases):
+		qEval.Status = "correct"
+		qEval.Marks = float64(q.SuccessMarks)
+	case result.Passed > 0:
+		qEval.Status = "partial"
+		qEval.Marks = float64(q.SuccessMarks) * float64(result.Passed) / float64(len(q.TestCases))
+	default:
+		qEval.Status = "incorrect"
+		qEval.Marks = float64(q.FailureMarks)
+	}
+}
+
+// anyFilled reports whether at least one blank of a fill-blank answer was filled in
+func anyFilled(blanks []string) bool {
+	for _, b := range blanks {
+		if strings.TrimSpace(b) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// blankPlaceholder is how blank n is marked in the question text
+func blankPlaceholder(n int) string {
+	return fmt.Sprintf("[[%d]]", n)
+}
+
+// blankAccepted reports whether an answer fills the blank, comparing normalised text or
+// matching the normalised answer against the accepted patterns
+func blankAccepted(blank Blank, answer string) bool {
+	answer = normaliseBlankAnswer(answer, blank)
+	if answer == "" {
+		return false
+	}
+	for _, accepted := range blank.AcceptedAnswers {
+		if blank.Regex {
+			re, err := blankPattern(accepted, blank)
+			if err == nil && re.MatchString(answer) {
+				return true
+			}
+		} else if answer == normaliseBlankAnswer(accepted, blank) {
+			return true
+		}
+	}
+	return false
+}
+
+// blankPattern compiles an accepted answer pattern so that it has to match the whole answer
+func blankPattern(pattern string, blank Blank) (*regexp.Regexp, error) {
+	flags := ""
+	if !blank.CaseSensitive {
+		flags = "(?i)"
+	}
+	return regexp.Compile(flags + "^(?:" + pattern + ")$")
+}
+
+// normaliseBlankAnswer trims and collapses whitespace, composes accents the same way whatever the
+// keyboard produced, and applies the blank's case and diacritic rules
+func normaliseBlankAnswer(s string, blank Blank) string {
+	s = strings.Join(strings.Fields(norm.NFC.String(s)), " ")
+	if blank.IgnoreDiacritics {
+		// A transformer keeps state, so a new chain is built for every call
+		stripMarks := transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+		if stripped, _, err := transform.String(stripMarks, s); err == nil {
+			s = stripped
+		}
+	}
+	if !blank.CaseSensitive {
+		s = strings.ToLower(s)
+	}
+	return s
+}
+
+// numericAccepted reports whether value falls in the question's accepted range, or within the
+// tolerance of its numericAnswer. A little slack absorbs float rounding at the boundaries,
+// so 9.8 is accepted for 9.81 with a tolerance of 0.01.
+func numericAccepted(q Question, value float64) bool {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return false
+	}
+	if q.NumericAnswer == nil {
+		if q.MinValue == nil || q.MaxValue == nil {
+			return false
+		}
+		slack := 1e-9 * math.Max(1, math.Max(math.Abs(*q.MinValue), math.Abs(*q.MaxValue)))
+		return value >= *q.MinValue-slack && value <= *q.MaxValue+slack
+	}
+	expected := *q.NumericAnswer
+	tolerance := q.Tolerance
+	if q.ToleranceType == "relative" {
+		tolerance = q.Tolerance * math.Abs(expected)
+	}
+	slack := 1e-9 * math.Max(1, math.Abs(expected))
+	return math.Abs(value-expected) <= tolerance+slack
+}
+
+// MSQ scoring policies, all-or-nothing is used when none is set
+var msqScoringPolicies = map[string]bool{
+	"all-or-nothing":       true, // exact selection scores successMarks, anything else failureMarks
+	"proportional":         true, // successMarks scaled by the share of correct options picked, wrong picks ignored
+	"proportional-penalty": true, // each correct pick adds and each wrong pick removes successMarks/#correct, floored at failureMarks
+	"any-wrong-fails":      true, // any wrong pick scores failureMarks, otherwise proportional
+}
+
+// scoreMSQ applies the question's scoring policy to the selected options
+func scoreMSQ(q Question, selected []int) float64 {
+	if q.ScoringPolicy == "" || q.ScoringPolicy == "all-or-nothing" {
+		if sameOptionSet(selected, q.CorrectOptions) {
+			return float64(q.SuccessMarks)
+		}
+		return float64(q.FailureMarks)
+	}
+
+	correctSet := map[int]bool{}
+	for _, o := range q.CorrectOptions {
+		correctSet[o] = true
+	}
+	selectedSet := map[int]bool{}
+	for _, o := range selected {
+		selectedSet[o] = true
+	}
+	right, wrong := 0, 0
+	for o := range selectedSet {
+		if correctSet[o] {
+			right++
+		} else {
+			wrong++
+		}
+	}
+	perOption := float64(q.SuccessMarks) / float64(len(correctSet))
+
+	switch q.ScoringPolicy {
+	case "proportional":
+		return perOption * float64(right)
+	case "proportional-penalty":
+		marks := perOption * float64(right-wrong)
+		if marks < float64(q.FailureMarks) {
+			marks = float64(q.FailureMarks)
+		}
+		return marks
+	case "any-wrong-fails":
+		if wrong > 0 {
+			return float64(q.FailureMarks)
+		}
+		return perOption * float64(right)
+	}
+	return 0
+}
+
+// sameOptionSet reports whether both slices select exactly the same options, ignoring order and duplicates
+func sameOptionSet(selected, correct []int) bool {
+	selectedSet := map[int]bool{}
+	for _, o := range selected {
+		selectedSet[o] = true
+	}
+	correctSet := map[int]bool{}
+	for _, o := range correct {
+		correctSet[o] = true
+	}
+	if len(selectedSet) != len(correctSet) {
+		return false
+	}
+	for o := range correctSet {
+		if !selectedSet[o] {
+			return false
+		}
+	}
+	return true
+}
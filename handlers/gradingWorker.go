@@ -0,0 +1,254 @@
+package handlers
+
+import (
+	"log"
+	"time"
+
+	"github.com/Qubitopia/quantum-scholar-backend/database"
+	"github.com/Qubitopia/quantum-scholar-backend/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	gradingWorkers  = 4
+	gradingInterval = 15 * time.Second
+	// A worker grading an attempt refreshes its heartbeat this often, grading can take as long
+	// as its code questions need to run
+	gradingHeartbeatInterval = 30 * time.Second
+	// A claim whose heartbeat is older than this belongs to a replica that died mid-grading, the
+	// attempt is graded again
+	gradingClaimTimeout = 2 * time.Minute
+	// How long an attempt that failed to grade waits before it is retried
+	gradingRetryDelay = 5 * time.Minute
+	// Tries, counting claims lost to a dead replica, before an attempt is marked failed. A failed
+	// attempt is retried once the examiner re-grades the whole test.
+	maxGradingTries = 5
+)
+
+// claimableAttempt matches submitted attempts that a grading worker may claim: waiting, past
+// their retry delay, or held by a claim that has gone stale
+const claimableAttempt = "status = 'submitted' AND grading_tries < ? AND " +
+	"((grading_status = 'pending' AND grading_heartbeat < ?) OR (grading_status = 'grading' AND grading_heartbeat < ?))"
+
+func claimableArgs(now time.Time) []interface{} {
+	return []interface{}{maxGradingTries, now.Add(-gradingRetryDelay), now.Add(-gradingClaimTimeout)}
+}
+
+// gradingQueue hands attempts submitted on this replica to its grading workers straight away.
+// The workers also sweep the table, so attempts queued on other replicas or dropped from a full
+// queue are still graded.
+var gradingQueue = make(chan uint64, 256)
+
+// queueGrading asks the grading workers to grade an attempt, without waiting for them
+func queueGrading(attemptID uint64) {
+	select {
+	case gradingQueue <- attemptID:
+	default:
+		// The next sweep picks it up
+	}
+}
+
+// StartGradingWorker starts the workers that grade submitted attempts. Every attempt is
+// claimed before grading and the claim is kept alive by a heartbeat, so replicas and workers
+// never grade the same attempt at once and no lock has to outlast a slow grader.
+func StartGradingWorker() {
+	for i := 0; i < gradingWorkers; i++ {
+		go func() {
+			ticker := time.NewTicker(gradingInterval)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case attemptID := <-gradingQueue:
+					gradeSubmittedAttempt(attemptID)
+				case <-ticker.C:
+					gradePendingAttempts()
+				}
+			}
+		}()
+	}
+}
+
+// gradePendingAttempts claims and grades waiting attempts one at a time, oldest first, until
+// none are left. Rows another worker is claiming are skipped rather than waited for.
+func gradePendingAttempts() {
+	failStaleClaims()
+	for {
+		attemptID, claimed, ok := claimNextAttempt()
+		if !ok {
+			return
+		}
+		gradeClaimedAttempt(attemptID, claimed)
+	}
+}
+
+// failStaleClaims marks attempts failed whose last try died with its replica
+func failStaleClaims() {
+	result := database.DB.Model(&models.AnswerAttempt{}).
+		Where("status = ? AND grading_status = ? AND grading_tries >= ? AND grading_heartbeat < ?",
+			"submitted", "grading", maxGradingTries, time.Now().Add(-gradingClaimTimeout)).
+		Update("grading_status", "failed")
+	if result.Error != nil {
+		log.Println("Grading: failed to mark stale attempts as failed:", result.Error)
+		return
+	}
+	if result.RowsAffected > 0 {
+		log.Printf("Grading: giving up on %d attempts after %d tries, re-grade their tests to retry", result.RowsAffected, maxGradingTries)
+	}
+}
+
+// claimNextAttempt claims the oldest claimable attempt, it reports false when there is none
+func claimNextAttempt() (uint64, time.Time, bool) {
+	// Postgres keeps microseconds, the claim is compared again when the evaluation is saved
+	claimed := time.Now().Truncate(time.Microsecond)
+	var attempts []models.AnswerAttempt
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Select("answer_id").
+			Where(claimableAttempt, claimableArgs(claimed)...).
+			Order("submit_time").
+			Limit(1).
+			Find(&attempts).Error; err != nil {
+			return err
+		}
+		if len(attempts) == 0 {
+			return nil
+		}
+		return tx.Model(&models.AnswerAttempt{}).
+			Where("answer_id = ?", attempts[0].AnswerID).
+			Updates(claimUpdates(claimed)).Error
+	})
+	if err != nil {
+		log.Println("Grading: failed to claim a pending attempt:", err)
+		return 0, time.Time{}, false
+	}
+	if len(attempts) == 0 {
+		return 0, time.Time{}, false
+	}
+	return attempts[0].AnswerID, claimed, true
+}
+
+// claimUpdates are the column changes that claim an attempt for grading
+func claimUpdates(claimed time.Time) map[string]interface{} {
+	return map[string]interface{}{
+		"grading_status":    "grading",
+		"grading_claimed":   claimed,
+		"grading_heartbeat": claimed,
+		"grading_tries":     gorm.Expr("grading_tries + 1"),
+	}
+}
+
+// gradeSubmittedAttempt claims a submitted attempt, if it can still be claimed, and grades it
+func gradeSubmittedAttempt(attemptID uint64) {
+	claimed := time.Now().Truncate(time.Microsecond)
+	result := database.DB.Model(&models.AnswerAttempt{}).
+		Where("answer_id = ? AND "+claimableAttempt, append([]interface{}{attemptID}, claimableArgs(claimed)...)...).
+		Updates(claimUpdates(claimed))
+	if result.Error != nil {
+		log.Printf("Grading: failed to claim attempt %d: %v", attemptID, result.Error)
+		return
+	}
+	if result.RowsAffected == 0 {
+		// Already graded, or another worker has it
+		return
+	}
+	gradeClaimedAttempt(attemptID, claimed)
+}
+
+// gradeClaimedAttempt grades an attempt this worker has claimed and stores the evaluation. An
+// attempt that fails to grade is retried after gradingRetryDelay until it runs out of tries.
+func gradeClaimedAttempt(attemptID uint64, claimed time.Time) {
+	stop := make(chan struct{})
+	defer close(stop)
+	go keepClaimAlive(attemptID, claimed, stop)
+
+	var attempt models.AnswerAttempt
+	if err := database.DB.Where("answer_id = ?", attemptID).First(&attempt).Error; err != nil {
+		log.Printf("Grading: failed to fetch attempt %d: %v", attemptID, err)
+		releaseFailedClaim(attemptID, claimed)
+		return
+	}
+	var test models.Test
+	if err := database.DB.Where("test_id = ?", attempt.TestID).First(&test).Error; err != nil {
+		log.Printf("Grading: failed to fetch test %d of attempt %d: %v", attempt.TestID, attemptID, err)
+		releaseFailedClaim(attemptID, claimed)
+		return
+	}
+	if err := gradeAttempt(&test, &attempt); err != nil {
+		log.Printf("Grading: failed to grade attempt %d (try %d of %d): %v", attemptID, attempt.GradingTries, maxGradingTries, err)
+		releaseFailedClaim(attemptID, claimed)
+		return
+	}
+
+	// Saved only while the claim is still ours, a worker that took it over has the final say
+	result := database.DB.Model(&models.AnswerAttempt{}).
+		Where("answer_id = ? AND grading_status = ? AND grading_claimed = ?", attemptID, "grading", claimed).
+		Updates(map[string]interface{}{
+			"evaluation_json": attempt.EvaluationJSON,
+			"achieved_marks":  attempt.AchievedMarks,
+			"grading_status":  "graded",
+		})
+	if result.Error != nil {
+		log.Printf("Grading: failed to save attempt %d (try %d of %d): %v", attemptID, attempt.GradingTries, maxGradingTries, result.Error)
+		releaseFailedClaim(attemptID, claimed)
+		return
+	}
+	if result.RowsAffected == 0 {
+		return
+	}
+	attempt.GradingStatus = "graded"
+
+	// Once results are out the candidate hears about theirs as soon as it is graded, attempts
+	// graded before the release are sent theirs by the release itself
+	if test.ResultsRelease == "immediate" || !test.ResultsReleasedAt.IsZero() {
+		if test.EmailResults {
+			emailResults(test, []models.AnswerAttempt{attempt})
+		}
+		issueCredentials(test, []models.AnswerAttempt{attempt})
+	}
+}
+
+// keepClaimAlive refreshes the heartbeat of a claim until stop is closed or the claim is lost
+func keepClaimAlive(attemptID uint64, claimed time.Time, stop <-chan struct{}) {
+	ticker := time.NewTicker(gradingHeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			result := database.DB.Model(&models.AnswerAttempt{}).
+				Where("answer_id = ? AND grading_status = ? AND grading_claimed = ?", attemptID, "grading", claimed).
+				Update("grading_heartbeat", time.Now())
+			if result.Error != nil {
+				log.Printf("Grading: failed to refresh the claim on attempt %d: %v", attemptID, result.Error)
+				continue
+			}
+			if result.RowsAffected == 0 {
+				return
+			}
+		}
+	}
+}
+
+// releaseFailedClaim gives up a claim after a failed try. The attempt waits for its next try,
+// or is marked failed once it has used them all.
+func releaseFailedClaim(attemptID uint64, claimed time.Time) {
+	var attempt models.AnswerAttempt
+	result := database.DB.Model(&attempt).
+		Clauses(clause.Returning{Columns: []clause.Column{{Name: "grading_status"}}}).
+		Where("answer_id = ? AND grading_status = ? AND grading_claimed = ?", attemptID, "grading", claimed).
+		Updates(map[string]interface{}{
+			"grading_status":    gorm.Expr("CASE WHEN grading_tries >= ? THEN 'failed' ELSE 'pending' END", maxGradingTries),
+			"grading_heartbeat": time.Now(),
+		})
+	if result.Error != nil {
+		log.Printf("Grading: failed to release attempt %d, it is retried once the claim goes stale: %v", attemptID, result.Error)
+		return
+	}
+	if attempt.GradingStatus == "failed" {
+		log.Printf("Grading: giving up on attempt %d after %d tries, re-grade its test to retry", attemptID, maxGradingTries)
+	}
+}
@@ -0,0 +1,294 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Qubitopia/quantum-scholar-backend/database"
+	"github.com/Qubitopia/quantum-scholar-backend/grader"
+	"github.com/Qubitopia/quantum-scholar-backend/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm/clause"
+)
+
+type ManualGradeRequest struct {
+	AttemptID      uint64   `json:"attempt_id" binding:"required"`
+	SectionID      int      `json:"section_id" binding:"required"`
+	QuestionNumber int      `json:"question_number" binding:"required"`
+	Marks          *float64 `json:"marks"` // required unless the question has a rubric
+	Comment        string   `json:"comment"`
+	// Required for questions with a rubric, the marks are then the sum of the criteria
+	CriterionScores []grader.CriterionScore `json:"criterion_scores"`
+}
+
+type PendingResponse struct {
+	AttemptID       uint64   `json:"attempt_id"`
+	CandidateEmail  string   `json:"candidate_email,omitempty"`
+	CandidateName   string   `json:"candidate_name,omitempty"`
+	SectionID       int      `json:"section_id"`
+	QuestionNumber  int      `json:"question_number"`
+	QuestionText    string   `json:"question_text"`
+	ModelAnswer     string   `json:"model_answer"`
+	CandidateAnswer string   `json:"candidate_answer"`
+	MinMarks        float64  `json:"min_marks"`
+	MaxMarks        float64  `json:"max_marks"`
+	Status          string   `json:"status"`
+	SuggestedMarks  *float64 `json:"suggested_marks,omitempty"`
+	AIMarks         *float64 `json:"ai_marks,omitempty"`
+	Confidence      float64  `json:"confidence,omitempty"`
+	Rationale       string   `json:"rationale,omitempty"`
+	// Rubric to mark against and the grader's per-criterion suggestion
+	Rubric          []grader.Criterion      `json:"rubric,omitempty"`
+	CriterionScores []grader.CriterionScore `json:"criterion_scores,omitempty"`
+}
+
+// GetPendingResponses lists submitted open-ended answers awaiting a manual grade.
+// Pass ?anonymise=true to hide candidate identities from the grader and
+// ?include_ai_graded=true to also review answers the AI grader marked on its own.
+func GetPendingResponses(c *gin.Context) {
+	test, ok := getOwnedTest(c)
+	if !ok {
+		return
+	}
+	anonymise := c.Query("anonymise") == "true"
+	includeAIGraded := c.Query("include_ai_graded") == "true"
+
+	keyByQuestion, err := indexAnswerKey(test.QuestionAnswerJSON)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var attempts []models.AnswerAttempt
+	if err := database.DB.Where("test_id = ? AND status = ? AND grading_status = ?", test.TestID, "submitted", "graded").Order("answer_id").Find(&attempts).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch attempts"})
+		return
+	}
+
+	// Candidate details are only needed when not anonymised
+	candidateByID := map[uint32]models.User{}
+	if !anonymise && len(attempts) > 0 {
+		candidateIDs := make([]uint32, 0, len(attempts))
+		for _, a := range attempts {
+			candidateIDs = append(candidateIDs, a.CandidateID)
+		}
+		var candidates []models.User
+		if err := database.DB.Where("id IN ?", candidateIDs).Find(&candidates).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch candidates"})
+			return
+		}
+		for _, u := range candidates {
+			candidateByID[u.ID] = u
+		}
+	}
+
+	responses := []PendingResponse{}
+	for _, attempt := range attempts {
+		var evaluation Evaluation
+		if err := json.Unmarshal([]byte(attempt.EvaluationJSON), &evaluation); err != nil {
+			continue
+		}
+		if evaluation.PendingReview == 0 && !includeAIGraded {
+			continue
+		}
+		answerByQuestion, err := indexAnswers(attempt.AnswerJSON, attempt.ShuffleJSON)
+		if err != nil {
+			continue
+		}
+		for _, sec := range evaluation.Sections {
+			for _, qEval := range sec.Questions {
+				if qEval.Status != "pending" && (qEval.Status != "ai-graded" || !includeAIGraded) {
+					continue
+				}
+				q := keyByQuestion[sec.SectionID][qEval.QuestionNumber]
+				response := PendingResponse{
+					AttemptID:       attempt.AnswerID,
+					SectionID:       sec.SectionID,
+					QuestionNumber:  qEval.QuestionNumber,
+					QuestionText:    q.QuestionText,
+					ModelAnswer:     q.ModelAnswer,
+					MinMarks:        float64(q.FailureMarks),
+					MaxMarks:        qEval.MaxMarks,
+					Status:          qEval.Status,
+					SuggestedMarks:  qEval.SuggestedMarks,
+					Confidence:      qEval.Confidence,
+					Rationale:       qEval.Rationale,
+					Rubric:          q.Rubric,
+					CriterionScores: qEval.CriterionScores,
+				}
+				if qEval.Status == "ai-graded" {
+					marks := qEval.Marks
+					response.AIMarks = &marks
+				}
+				if a := answerByQuestion[sec.SectionID][qEval.QuestionNumber]; a != nil && a.Answer != nil {
+					response.CandidateAnswer = *a.Answer
+				} else if a != nil && a.Code != nil {
+					response.CandidateAnswer = *a.Code
+				}
+				if !anonymise {
+					response.CandidateEmail = candidateByID[attempt.CandidateID].Email
+					response.CandidateName = candidateByID[attempt.CandidateID].Name
+				}
+				responses = append(responses, response)
+			}
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"pending": responses, "count": len(responses)})
+}
+
+// SubmitManualGrade records an examiner's score and comment for one open-ended answer
+func SubmitManualGrade(c *gin.Context) {
+	test, ok := getOwnedTest(c)
+	if !ok {
+		return
+	}
+
+	var req ManualGradeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	keyByQuestion, err := indexAnswerKey(test.QuestionAnswerJSON)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	q, ok := keyByQuestion[req.SectionID][req.QuestionNumber]
+	if !ok || (q.Type != "open-ended" && q.Type != "code") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Only open-ended and code questions can be graded manually"})
+		return
+	}
+
+	// With a rubric every criterion is scored and the marks are their sum
+	var criterionScores []grader.CriterionScore
+	if len(q.Rubric) > 0 {
+		scoreByID := map[string]grader.CriterionScore{}
+		for _, cs := range req.CriterionScores {
+			scoreByID[cs.ID] = cs
+		}
+		total := 0.0
+		for _, criterion := range q.Rubric {
+			cs, ok := scoreByID[criterion.ID]
+			if !ok {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Missing score for rubric criterion %s", criterion.ID)})
+				return
+			}
+			if cs.Points < 0 || cs.Points > criterion.Points {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Rubric criterion %s must score between 0 and %g", criterion.ID, criterion.Points)})
+				return
+			}
+			criterionScores = append(criterionScores, grader.CriterionScore{
+				ID:        criterion.ID,
+				Points:    cs.Points,
+				MaxPoints: criterion.Points,
+				Comment:   cs.Comment,
+			})
+			total += cs.Points
+		}
+		req.Marks = &total
+	}
+	if req.Marks == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "marks is required"})
+		return
+	}
+	if *req.Marks > float64(q.SuccessMarks) || *req.Marks < float64(q.FailureMarks) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Marks must be between %d and %d", q.FailureMarks, q.SuccessMarks)})
+		return
+	}
+
+	// The attempt is locked from reading the evaluation to saving it, so grades of other
+	// answers of the same attempt saved at the same time are not lost
+	tx := database.DB.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+	var attempt models.AnswerAttempt
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("answer_id = ? AND test_id = ?", req.AttemptID, test.TestID).First(&attempt).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusNotFound, gin.H{"error": "Attempt not found"})
+		return
+	}
+	if attempt.Status != "submitted" {
+		tx.Rollback()
+		c.JSON(http.StatusConflict, gin.H{"error": "Only submitted attempts can be graded"})
+		return
+	}
+	if attempt.GradingStatus != "graded" {
+		tx.Rollback()
+		c.JSON(http.StatusConflict, gin.H{"error": "Attempt is still being graded automatically"})
+		return
+	}
+
+	var evaluation Evaluation
+	if err := json.Unmarshal([]byte(attempt.EvaluationJSON), &evaluation); err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid evaluation for this attempt"})
+		return
+	}
+
+	// Find the response in the evaluation, manual and AI grades can be revised
+	var target *QuestionEvaluation
+	for i := range evaluation.Sections {
+		if evaluation.Sections[i].SectionID != req.SectionID {
+			continue
+		}
+		for j := range evaluation.Sections[i].Questions {
+			if evaluation.Sections[i].Questions[j].QuestionNumber == req.QuestionNumber {
+				target = &evaluation.Sections[i].Questions[j]
+			}
+		}
+	}
+	if target == nil || (target.Status != "pending" && target.Status != "graded" && target.Status != "ai-graded") {
+		tx.Rollback()
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No answer awaiting grading for this question"})
+		return
+	}
+
+	now := time.Now()
+	target.Status = "graded"
+	target.Marks = *req.Marks
+	target.Comment = req.Comment
+	target.CriterionScores = criterionScores
+	target.GradedBy = test.ExaminerID // getOwnedTest checked that the user owns the test
+	target.GradedAt = &now
+	evaluation.recomputeTotals()
+
+	evaluationJSONBytes, err := json.Marshal(evaluation)
+	if err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to marshal evaluation"})
+		return
+	}
+	attempt.EvaluationJSON = string(evaluationJSONBytes)
+	attempt.AchievedMarks = evaluation.AchievedMarks
+	if err := tx.Model(&attempt).Updates(map[string]interface{}{
+		"evaluation_json": attempt.EvaluationJSON,
+		"achieved_marks":  attempt.AchievedMarks,
+	}).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save grade"})
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save grade"})
+		return
+	}
+
+	// The last graded answer may complete a passing attempt
+	if evaluation.PendingReview == 0 {
+		go issueCredentials(test, []models.AnswerAttempt{attempt})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "Answer graded successfully",
+		"achieved_marks": attempt.AchievedMarks,
+		"pending_review": evaluation.PendingReview,
+	})
+}
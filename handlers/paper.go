@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+
+	"github.com/Qubitopia/quantum-scholar-backend/database"
+	"github.com/Qubitopia/quantum-scholar-backend/models"
+	"github.com/gin-gonic/gin"
+)
+
+// PaperSection lists the answer key of the questions a candidate was given, in the order shown
+type PaperSection struct {
+	SectionID int        `json:"section_id"`
+	Questions []Question `json:"questions"`
+}
+
+// GetAttemptPaper shows the examiner the paper generated for an attempt, the answer key of its
+// questions and whether generating it again from the stored seed gives the same paper
+func GetAttemptPaper(c *gin.Context) {
+	test, ok := getOwnedTest(c)
+	if !ok {
+		return
+	}
+
+	var attemptID uint64
+	if _, err := fmt.Sscanf(c.Param("attempt_id"), "%d", &attemptID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid attempt id"})
+		return
+	}
+	var attempt models.AnswerAttempt
+	if err := database.DB.Where("answer_id = ? AND test_id = ?", attemptID, test.TestID).First(&attempt).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Attempt not found"})
+		return
+	}
+
+	answerKey, err := paperAnswerKey(test.QuestionAnswerJSON, attempt.QuestionJSON)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	response := gin.H{
+		"attempt_id":   attempt.AnswerID,
+		"candidate_id": attempt.CandidateID,
+		"status":       attempt.Status,
+		"seed":         attempt.PaperSeed,
+		"paper":        json.RawMessage(attempt.QuestionJSON),
+		"shuffle":      json.RawMessage(attempt.ShuffleJSON),
+		"answer_key":   answerKey,
+	}
+
+	// Attempts from before seeds were recorded cannot be generated again
+	if attempt.PaperSeed == "" {
+		response["reproducible"] = false
+		c.JSON(http.StatusOK, response)
+		return
+	}
+	questionJSON, shuffleJSON, err := generatePaper(test, attempt.PaperSeed)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate the paper again: " + err.Error()})
+		return
+	}
+	// A mismatch means the test changed since, e.g. visible test cases corrected in the answer key
+	response["reproducible"] = sameJSON(questionJSON, attempt.QuestionJSON) && sameJSON(shuffleJSON, attempt.ShuffleJSON)
+
+	c.JSON(http.StatusOK, response)
+}
+
+// paperAnswerKey looks up the answer key of every question in a candidate's paper
+func paperAnswerKey(questionAnswerJSON, questionJSON string) ([]PaperSection, error) {
+	keyByQuestion, err := indexAnswerKey(questionAnswerJSON)
+	if err != nil {
+		return nil, err
+	}
+	var paper struct {
+		Sections []struct {
+			SectionID int `json:"sectionId"`
+			Questions []struct {
+				QuestionNumber int `json:"questionNumber"`
+			} `json:"questions"`
+		} `json:"sections"`
+	}
+	if err := json.Unmarshal([]byte(questionJSON), &paper); err != nil {
+		return nil, fmt.Errorf("invalid paper: %w", err)
+	}
+
+	sections := []PaperSection{}
+	for _, sec := range paper.Sections {
+		ps := PaperSection{SectionID: sec.SectionID, Questions: []Question{}}
+		for _, q := range sec.Questions {
+			if key, ok := keyByQuestion[sec.SectionID][q.QuestionNumber]; ok {
+				ps.Questions = append(ps.Questions, key)
+			}
+		}
+		sections = append(sections, ps)
+	}
+	return sections, nil
+}
+
+// sameJSON compares two JSON documents by value, jsonb columns do not keep key order or spacing
+func sameJSON(a, b string) bool {
+	var va, vb interface{}
+	if json.Unmarshal([]byte(a), &va) != nil || json.Unmarshal([]byte(b), &vb) != nil {
+		return false
+	}
+	return reflect.DeepEqual(va, vb)
+}
@@ -0,0 +1,398 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Qubitopia/quantum-scholar-backend/database"
+	"github.com/Qubitopia/quantum-scholar-backend/models"
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+	"gorm.io/gorm"
+)
+
+// Difficulty levels a question can be rated with
+var difficultyLevels = map[string]bool{"easy": true, "medium": true, "hard": true}
+
+// BankRule draws count questions from the examiner's question bank for every candidate, such as
+// 5 hard algebra questions. Every filter that is set has to match, a question needs all the tags.
+type BankRule struct {
+	Count      int      `json:"count"`
+	Type       string   `json:"type,omitempty"`
+	Topic      string   `json:"topic,omitempty"` // compared ignoring case
+	Difficulty string   `json:"difficulty,omitempty"`
+	Tags       []string `json:"tags,omitempty"`
+}
+
+type BankQuestionRequest struct {
+	// Checked by validateQuestion, the binding tags of Question are for tests and questionNumber is not used in the bank
+	Question Question `json:"question" binding:"-"`
+}
+
+// Page size of the question bank search
+const (
+	defaultBankPageSize = 50
+	maxBankPageSize     = 200
+)
+
+func CreateBankQuestion(c *gin.Context) {
+	user, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found in context"})
+		return
+	}
+
+	examiner, ok := user.(models.User)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user from context"})
+		return
+	}
+
+	var req BankQuestionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	bq := models.BankQuestion{OwnerID: examiner.ID}
+	if err := fillBankQuestion(&bq, req.Question); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := database.DB.Create(&bq).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save question to the bank"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Question added to the bank", "bank_question_id": bq.BankQuestionID})
+}
+
+// SearchBankQuestions lists the user's bank questions, filtered by the type, topic, difficulty,
+// tag (repeatable, all must match) and q (text in the question) query parameters
+func SearchBankQuestions(c *gin.Context) {
+	user, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found in context"})
+		return
+	}
+
+	examiner, ok := user.(models.User)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user from context"})
+		return
+	}
+
+	filter := BankRule{
+		Type:       c.Query("type"),
+		Topic:      c.Query("topic"),
+		Difficulty: c.Query("difficulty"),
+		Tags:       c.QueryArray("tag"),
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultBankPageSize)))
+	if err != nil || limit < 1 || limit > maxBankPageSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be between 1 and %d", maxBankPageSize)})
+		return
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "offset must be zero or more"})
+		return
+	}
+
+	query := filterBankQuestions(database.DB.Model(&models.BankQuestion{}).Where("owner_id = ?", examiner.ID), filter)
+	if text := strings.TrimSpace(c.Query("q")); text != "" {
+		escaped := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(text)
+		query = query.Where("question_json->>'questionText' ILIKE ?", "%"+escaped+"%")
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search the question bank"})
+		return
+	}
+	questions := []models.BankQuestion{}
+	if err := query.Order("bank_question_id DESC").Limit(limit).Offset(offset).Find(&questions).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search the question bank"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"questions": questions, "total": total})
+}
+
+func GetBankQuestionByID(c *gin.Context) {
+	bq, ok := getOwnedBankQuestion(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, bq)
+}
+
+// UpdateBankQuestion replaces a bank question, tests that were already published keep their copy
+func UpdateBankQuestion(c *gin.Context) {
+	bq, ok := getOwnedBankQuestion(c)
+	if !ok {
+		return
+	}
+
+	var req BankQuestionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := fillBankQuestion(&bq, req.Question); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := database.DB.Save(&bq).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update bank question"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Bank question updated successfully"})
+}
+
+// DeleteBankQuestion removes a question from the bank, draft tests referring to it can no longer be published
+func DeleteBankQuestion(c *gin.Context) {
+	bq, ok := getOwnedBankQuestion(c)
+	if !ok {
+		return
+	}
+	if err := database.DB.Delete(&bq).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete bank question"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Bank question deleted successfully"})
+}
+
+// getOwnedBankQuestion loads the bank question in the :id param and checks that the user owns it.
+// On failure the response is already written.
+func getOwnedBankQuestion(c *gin.Context) (models.BankQuestion, bool) {
+	var bq models.BankQuestion
+
+	user, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found in context"})
+		return bq, false
+	}
+
+	examiner, ok := user.(models.User)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user from context"})
+		return bq, false
+	}
+
+	var id uint32
+	if _, err := fmt.Sscanf(c.Param("id"), "%d", &id); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid bank question id"})
+		return bq, false
+	}
+	if err := database.DB.First(&bq, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Bank question not found"})
+		return bq, false
+	}
+	if bq.OwnerID != examiner.ID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You are not the owner of this bank question"})
+		return bq, false
+	}
+	return bq, true
+}
+
+// fillBankQuestion validates q and stores it in bq along with the columns it is searched by
+func fillBankQuestion(bq *models.BankQuestion, q Question) error {
+	q.QuestionNumber, q.BankQuestionID, q.BankRule = 0, 0, 0
+	q.Topic = strings.TrimSpace(q.Topic)
+	q.Tags = normaliseTags(q.Tags)
+	if err := validateQuestion(q); err != nil {
+		return err
+	}
+	// Images are uploaded to a test, a question kept outside of any test cannot point to them
+	if q.Image != "" || len(q.OptionImages) > 0 {
+		return fmt.Errorf("questions with images cannot be saved in the question bank")
+	}
+	sanitiseQuestion(&q)
+
+	questionJSON, err := json.Marshal(q)
+	if err != nil {
+		return fmt.Errorf("invalid question format")
+	}
+	bq.Type = q.Type
+	bq.Topic = q.Topic
+	bq.Difficulty = q.Difficulty
+	bq.Tags = pq.StringArray(q.Tags)
+	bq.QuestionJSON = string(questionJSON)
+	return nil
+}
+
+// normaliseTags lower-cases tags and drops blanks and repeats so that searches match however a tag was typed
+func normaliseTags(tags []string) []string {
+	seen := map[string]bool{}
+	out := []string{}
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag != "" && !seen[tag] {
+			seen[tag] = true
+			out = append(out, tag)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// filterBankQuestions narrows a bank question query to the questions a rule accepts
+func filterBankQuestions(query *gorm.DB, rule BankRule) *gorm.DB {
+	if rule.Type != "" {
+		query = query.Where("type = ?", rule.Type)
+	}
+	if topic := strings.TrimSpace(rule.Topic); topic != "" {
+		query = query.Where("LOWER(topic) = LOWER(?)", topic)
+	}
+	if rule.Difficulty != "" {
+		query = query.Where("difficulty = ?", rule.Difficulty)
+	}
+	if tags := normaliseTags(rule.Tags); len(tags) > 0 {
+		query = query.Where("tags @> ?", pq.StringArray(tags))
+	}
+	return query
+}
+
+// validateBankRule checks the filters of a rule, whether enough questions match is checked on publish
+func validateBankRule(rule BankRule) error {
+	if rule.Count < 1 {
+		return fmt.Errorf("count must be at least 1")
+	}
+	if rule.Type != "" && !questionTypes[rule.Type] {
+		return fmt.Errorf("invalid question type")
+	}
+	if rule.Difficulty != "" && !difficultyLevels[rule.Difficulty] {
+		return fmt.Errorf("difficulty must be easy, medium or hard")
+	}
+	return nil
+}
+
+// validateBankReferences checks that every bank question a test refers to exists and belongs to the examiner
+func validateBankReferences(tf TestFormat, ownerID uint32) error {
+	for i, section := range tf.Sections {
+		if len(section.BankQuestions) == 0 {
+			continue
+		}
+		var found []uint32
+		if err := database.DB.Model(&models.BankQuestion{}).
+			Where("owner_id = ? AND bank_question_id IN ?", ownerID, section.BankQuestions).
+			Pluck("bank_question_id", &found).Error; err != nil {
+			return fmt.Errorf("failed to look up bank questions")
+		}
+		exists := map[uint32]bool{}
+		for _, id := range found {
+			exists[id] = true
+		}
+		for _, id := range section.BankQuestions {
+			if !exists[id] {
+				return fmt.Errorf("section %d: bank question %d not found", i+1, id)
+			}
+		}
+	}
+	return nil
+}
+
+// resolveTestBankReferences copies the bank questions a test refers to into its question JSON
+func resolveTestBankReferences(test *models.Test) error {
+	var tf TestFormat
+	if err := json.Unmarshal([]byte(test.QuestionAnswerJSON), &tf); err != nil {
+		return fmt.Errorf("invalid question and answer format")
+	}
+	if err := resolveBankReferences(&tf, test.ExaminerID); err != nil {
+		return err
+	}
+	questionAnswerJSONBytes, err := json.Marshal(tf)
+	if err != nil {
+		return fmt.Errorf("invalid question and answer format")
+	}
+	test.QuestionAnswerJSON = string(questionAnswerJSONBytes)
+	return nil
+}
+
+// resolveBankReferences turns the bank questions of each section into questions of the section,
+// numbered after the ones already there. Every bank question matching a rule is added to the
+// section marked with the rule's number, candidates get count of them picked at random.
+// A bank question is copied at most once per test, referenced questions are taken first.
+// The questions of a rule must be worth the same marks, as candidates only get some of them.
+func resolveBankReferences(tf *TestFormat, ownerID uint32) error {
+	used := map[uint32]bool{}
+	nextNumber := make([]int, len(tf.Sections))
+	for i, section := range tf.Sections {
+		nextNumber[i] = 1
+		for _, q := range section.Questions {
+			if q.QuestionNumber >= nextNumber[i] {
+				nextNumber[i] = q.QuestionNumber + 1
+			}
+		}
+	}
+	add := func(i int, bq models.BankQuestion, rule int) error {
+		var q Question
+		if err := json.Unmarshal([]byte(bq.QuestionJSON), &q); err != nil {
+			return fmt.Errorf("bank question %d is not a valid question", bq.BankQuestionID)
+		}
+		q.QuestionNumber = nextNumber[i]
+		q.BankQuestionID = bq.BankQuestionID
+		q.BankRule = rule
+		nextNumber[i]++
+		used[bq.BankQuestionID] = true
+		tf.Sections[i].Questions = append(tf.Sections[i].Questions, q)
+		return nil
+	}
+
+	for i := range tf.Sections {
+		section := &tf.Sections[i]
+		if len(section.BankQuestions) == 0 {
+			continue
+		}
+		var referenced []models.BankQuestion
+		if err := database.DB.Where("owner_id = ? AND bank_question_id IN ?", ownerID, section.BankQuestions).Find(&referenced).Error; err != nil {
+			return fmt.Errorf("failed to load bank questions")
+		}
+		byID := map[uint32]models.BankQuestion{}
+		for _, bq := range referenced {
+			byID[bq.BankQuestionID] = bq
+		}
+		for _, id := range section.BankQuestions {
+			bq, ok := byID[id]
+			if !ok {
+				return fmt.Errorf("section %d: bank question %d not found", i+1, id)
+			}
+			if used[id] {
+				return fmt.Errorf("section %d: bank question %d is used more than once", i+1, id)
+			}
+			if err := add(i, bq, 0); err != nil {
+				return err
+			}
+		}
+		section.BankQuestions = nil
+	}
+
+	for i := range tf.Sections {
+		for k, rule := range tf.Sections[i].BankRules {
+			var matching []models.BankQuestion
+			query := filterBankQuestions(database.DB.Where("owner_id = ?", ownerID), rule)
+			if err := query.Order("bank_question_id").Find(&matching).Error; err != nil {
+				return fmt.Errorf("failed to load bank questions")
+			}
+			// Whether enough of them are left is checked with the rest of the test on publish
+			for _, bq := range matching {
+				if used[bq.BankQuestionID] {
+					continue
+				}
+				if err := add(i, bq, k+1); err != nil {
+					return err
+				}
+			}
+		}
+		if err := validateBankRuleMarks(tf.Sections[i]); err != nil {
+			return fmt.Errorf("section %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,446 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/Qubitopia/quantum-scholar-backend/database"
+	"github.com/Qubitopia/quantum-scholar-backend/models"
+	"github.com/Qubitopia/quantum-scholar-backend/runner"
+	"github.com/gin-gonic/gin"
+)
+
+type AnswerKeyCorrectionRequest struct {
+	SectionID      int               `json:"section_id" binding:"required"`
+	QuestionNumber int               `json:"question_number" binding:"required"`
+	CorrectOption  *int              `json:"correct_option"`  // mcq only
+	CorrectOptions []int             `json:"correct_options"` // msq only
+	ScoringPolicy  *string           `json:"scoring_policy"`  // msq only
+	ModelAnswer    *string           `json:"model_answer"`    // open-ended only
+	NumericAnswer  *float64          `json:"numeric_answer"`  // numeric only, replaces a range
+	Tolerance      *float64          `json:"tolerance"`       // numeric only
+	ToleranceType  *string           `json:"tolerance_type"`  // numeric only
+	MinValue       *float64          `json:"min_value"`       // numeric only, set both to replace an exact value
+	MaxValue       *float64          `json:"max_value"`       // numeric only
+	CorrectMatches []int             `json:"correct_matches"` // match only
+	Blanks         []Blank           `json:"blanks"`          // fill-blank only, same number of blanks
+	PartialCredit  *bool             `json:"partial_credit"`  // match, order and fill-blank only
+	TestCases      []runner.TestCase `json:"test_cases"`      // code only
+	Explanation    *string           `json:"explanation"`
+}
+
+type RegradeRequest struct {
+	// Both set to re-grade a single question, both left out to re-grade the whole test
+	SectionID      int    `json:"section_id"`
+	QuestionNumber int    `json:"question_number"`
+	Reason         string `json:"reason"`
+}
+
+// regradeAttempt grades an attempt again from scratch this many times when its evaluation is
+// changed by someone else while it is graded
+const regradeTries = 3
+
+type RegradeChange struct {
+	AttemptID   uint64  `json:"attempt_id"`
+	CandidateID uint32  `json:"candidate_id"`
+	Before      float64 `json:"before"`
+	After       float64 `json:"after"`
+}
+
+// CorrectAnswerKey fixes the answer key of one question after the test has been published.
+// Only the key can change, the question text and options the candidates saw stay locked.
+// Submitted attempts keep their marks until the test is re-graded.
+func CorrectAnswerKey(c *gin.Context) {
+	test, ok := getOwnedTest(c)
+	if !ok {
+		return
+	}
+	if test.Status == "archived" {
+		c.JSON(http.StatusConflict, gin.H{"error": "The answer key of an archived test cannot be changed"})
+		return
+	}
+
+	var req AnswerKeyCorrectionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var tf TestFormat
+	if err := json.Unmarshal([]byte(test.QuestionAnswerJSON), &tf); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid question and answer format"})
+		return
+	}
+
+	// Find the question to correct
+	var q *Question
+	for i := range tf.Sections {
+		if tf.Sections[i].SectionID != req.SectionID {
+			continue
+		}
+		for j := range tf.Sections[i].Questions {
+			if tf.Sections[i].Questions[j].QuestionNumber == req.QuestionNumber {
+				q = &tf.Sections[i].Questions[j]
+			}
+		}
+	}
+	if q == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Question not found"})
+		return
+	}
+
+	if req.CorrectOption != nil {
+		if q.Type != "mcq" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "correct_option can only be set on mcq questions"})
+			return
+		}
+		q.CorrectOption = *req.CorrectOption
+	}
+	if req.CorrectOptions != nil {
+		if q.Type != "msq" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "correct_options can only be set on msq questions"})
+			return
+		}
+		q.CorrectOptions = req.CorrectOptions
+	}
+	if req.ScoringPolicy != nil {
+		q.ScoringPolicy = *req.ScoringPolicy
+	}
+	if req.ModelAnswer != nil {
+		if q.Type != "open-ended" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "model_answer can only be set on open-ended questions"})
+			return
+		}
+		q.ModelAnswer = *req.ModelAnswer
+	}
+	if req.NumericAnswer != nil || req.Tolerance != nil || req.ToleranceType != nil || req.MinValue != nil || req.MaxValue != nil {
+		if q.Type != "numeric" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "numeric_answer, tolerance, tolerance_type, min_value and max_value can only be set on numeric questions"})
+			return
+		}
+		// An exact value and a range replace each other
+		if req.NumericAnswer != nil {
+			q.NumericAnswer = req.NumericAnswer
+			q.MinValue, q.MaxValue = nil, nil
+		}
+		if req.MinValue != nil || req.MaxValue != nil {
+			q.MinValue, q.MaxValue = req.MinValue, req.MaxValue
+			q.NumericAnswer, q.Tolerance, q.ToleranceType = nil, 0, ""
+		}
+		if req.Tolerance != nil {
+			q.Tolerance = *req.Tolerance
+		}
+		if req.ToleranceType != nil {
+			q.ToleranceType = *req.ToleranceType
+		}
+	}
+	if req.CorrectMatches != nil {
+		if q.Type != "match" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "correct_matches can only be set on match questions"})
+			return
+		}
+		q.CorrectMatches = req.CorrectMatches
+	}
+	if req.Blanks != nil {
+		if q.Type != "fill-blank" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "blanks can only be set on fill-blank questions"})
+			return
+		}
+		if len(req.Blanks) != len(q.Blanks) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("The question has %d blanks, the number of blanks cannot change", len(q.Blanks))})
+			return
+		}
+		q.Blanks = req.Blanks
+	}
+	if req.TestCases != nil {
+		if q.Type != "code" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "test_cases can only be set on code questions"})
+			return
+		}
+		q.TestCases = req.TestCases
+	}
+	if req.PartialCredit != nil {
+		q.PartialCredit = *req.PartialCredit
+	}
+	if req.Explanation != nil {
+		q.Explanation = *req.Explanation
+	}
+
+	if err := validateTestFormat(tf); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	sanitiseTestFormat(&tf)
+
+	questionAnswerJSONBytes, err := json.Marshal(tf)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid question and answer format"})
+		return
+	}
+	test.QuestionAnswerJSON = string(questionAnswerJSONBytes)
+	if err := database.DB.Save(&test).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update answer key"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Answer key corrected successfully, re-grade the test to update submitted attempts"})
+}
+
+// RegradeTest re-scores every graded attempt of a test, or only one question of each,
+// against the current answer key. Grading can call the AI grader and run code, so the re-grade
+// runs in the background; the response carries its id and GetRegrades shows the before and
+// after marks once it has completed. Attempts still waiting for the grading worker are graded
+// against the current answer key anyway, and a whole-test re-grade sends attempts that failed
+// to grade back to it.
+func RegradeTest(c *gin.Context) {
+	test, ok := getOwnedTest(c)
+	if !ok {
+		return
+	}
+	if test.Status == "draft" || test.Status == "archived" {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("A %s test cannot be re-graded", test.Status)})
+		return
+	}
+
+	var req RegradeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if (req.SectionID == 0) != (req.QuestionNumber == 0) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "section_id and question_number must be given together"})
+		return
+	}
+
+	keyByQuestion, err := indexAnswerKey(test.QuestionAnswerJSON)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	var question Question
+	if req.SectionID != 0 {
+		q, ok := keyByQuestion[req.SectionID][req.QuestionNumber]
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Question not found"})
+			return
+		}
+		question = q
+	}
+
+	// Attempts that gave up grading, often on a broken answer key, go back to the grading worker
+	var retried int64
+	if req.SectionID == 0 {
+		result := database.DB.Model(&models.AnswerAttempt{}).
+			Where("test_id = ? AND status = ? AND grading_status = ?", test.TestID, "submitted", "failed").
+			Updates(map[string]interface{}{
+				"grading_status":    "pending",
+				"grading_tries":     0,
+				"grading_heartbeat": time.Time{},
+			})
+		if result.Error != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retry attempts that failed to grade"})
+			return
+		}
+		retried = result.RowsAffected
+	}
+
+	var attemptIDs []uint64
+	if err := database.DB.Model(&models.AnswerAttempt{}).
+		Where("test_id = ? AND status = ? AND grading_status = ?", test.TestID, "submitted", "graded").
+		Order("answer_id").
+		Pluck("answer_id", &attemptIDs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch attempts"})
+		return
+	}
+
+	regrade := models.Regrade{
+		TestID:         test.TestID,
+		ExaminerID:     test.ExaminerID,
+		SectionID:      req.SectionID,
+		QuestionNumber: req.QuestionNumber,
+		Reason:         req.Reason,
+		Status:         "running",
+		AttemptsTotal:  len(attemptIDs),
+		ChangesJSON:    "[]",
+	}
+	if err := database.DB.Create(&regrade).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record the re-grade"})
+		return
+	}
+
+	go runRegrade(test, regrade, attemptIDs, question)
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message":          "Re-grade started",
+		"regrade_id":       regrade.RegradeID,
+		"attempts_total":   len(attemptIDs),
+		"attempts_retried": retried,
+	})
+}
+
+// runRegrade re-grades the attempts of a re-grade one at a time, replaces the credentials of
+// those whose score changed and records the outcome on the re-grade
+func runRegrade(test models.Test, regrade models.Regrade, attemptIDs []uint64, question Question) {
+	regraded, failed := 0, 0
+	changes := []RegradeChange{}
+	var changedAttempts []models.AnswerAttempt
+	for _, attemptID := range attemptIDs {
+		attempt, before, presented, err := regradeAttempt(&test, attemptID, question, regrade.SectionID)
+		if err != nil {
+			log.Printf("Regrade %d of test %d: failed to re-grade attempt %d: %v", regrade.RegradeID, test.TestID, attemptID, err)
+			failed++
+			continue
+		}
+		if !presented {
+			continue
+		}
+		regraded++
+		if math.Abs(attempt.AchievedMarks-before) > 1e-9 {
+			changes = append(changes, RegradeChange{
+				AttemptID:   attempt.AnswerID,
+				CandidateID: attempt.CandidateID,
+				Before:      before,
+				After:       attempt.AchievedMarks,
+			})
+			changedAttempts = append(changedAttempts, attempt)
+
+			// A signed credential carries the old score, replace it
+			reason := fmt.Sprintf("Score changed from %g to %g by re-grade %d", before, attempt.AchievedMarks, regrade.RegradeID)
+			if err := revokeCredentials("attempt_id = ?", attempt.AnswerID, test.ExaminerID, "superseded", reason); err != nil {
+				log.Printf("Regrade %d of test %d: failed to revoke credential of attempt %d: %v", regrade.RegradeID, test.TestID, attempt.AnswerID, err)
+			}
+		}
+	}
+	if len(changedAttempts) > 0 {
+		issueCredentials(test, changedAttempts)
+	}
+
+	changesJSONBytes, err := json.Marshal(changes)
+	if err != nil {
+		log.Printf("Regrade %d of test %d: failed to marshal changes: %v", regrade.RegradeID, test.TestID, err)
+		changesJSONBytes = []byte("[]")
+	}
+	if err := database.DB.Model(&models.Regrade{}).
+		Where("regrade_id = ?", regrade.RegradeID).
+		Updates(map[string]interface{}{
+			"status":            "completed",
+			"attempts_regraded": regraded,
+			"attempts_failed":   failed,
+			"scores_changed":    len(changes),
+			"changes_json":      string(changesJSONBytes),
+			"completed_at":      time.Now(),
+		}).Error; err != nil {
+		log.Printf("Regrade %d of test %d: failed to record the outcome: %v", regrade.RegradeID, test.TestID, err)
+	}
+}
+
+// GetRegrades lists the re-grades run on a test, most recent first
+func GetRegrades(c *gin.Context) {
+	test, ok := getOwnedTest(c)
+	if !ok {
+		return
+	}
+
+	var regrades []models.Regrade
+	if err := database.DB.Where("test_id = ?", test.TestID).Order("created_at desc").Find(&regrades).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch re-grades"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"regrades": regrades})
+}
+
+// regradeAttempt re-grades one attempt, or only one question of it when sectionID is set, and
+// returns it with its marks from before. Grading runs without holding a lock, the new
+// evaluation is saved only if the stored one is still the one graded; a manual grade saved
+// meanwhile wins and the attempt is graded again on top of it. It reports false when the
+// question was not on the candidate's paper.
+func regradeAttempt(test *models.Test, attemptID uint64, question Question, sectionID int) (models.AnswerAttempt, float64, bool, error) {
+	for try := 0; try < regradeTries; try++ {
+		var attempt models.AnswerAttempt
+		if err := database.DB.Where("answer_id = ?", attemptID).First(&attempt).Error; err != nil {
+			return attempt, 0, false, err
+		}
+		before := attempt.AchievedMarks
+		graded := attempt.EvaluationJSON
+
+		presented := true
+		var err error
+		if sectionID == 0 {
+			err = gradeAttempt(test, &attempt)
+		} else {
+			presented, err = rescoreQuestion(question, sectionID, &attempt)
+		}
+		if err != nil || !presented {
+			return attempt, before, presented, err
+		}
+
+		result := database.DB.Model(&models.AnswerAttempt{}).
+			Where("answer_id = ? AND evaluation_json = ?", attemptID, graded).
+			Updates(map[string]interface{}{
+				"evaluation_json": attempt.EvaluationJSON,
+				"achieved_marks":  attempt.AchievedMarks,
+			})
+		if result.Error != nil {
+			return attempt, before, presented, result.Error
+		}
+		if result.RowsAffected > 0 {
+			return attempt, before, presented, nil
+		}
+	}
+	return models.AnswerAttempt{}, 0, false, errors.New("the evaluation kept changing while it was re-graded")
+}
+
+// rescoreQuestion re-scores one question of an already graded attempt and leaves the rest of
+// the evaluation untouched. It reports false when the question was not on the candidate's paper.
+func rescoreQuestion(q Question, sectionID int, attempt *models.AnswerAttempt) (bool, error) {
+	var evaluation Evaluation
+	if err := json.Unmarshal([]byte(attempt.EvaluationJSON), &evaluation); err != nil {
+		return false, fmt.Errorf("invalid evaluation: %w", err)
+	}
+	answerByQuestion, err := indexAnswers(attempt.AnswerJSON, attempt.ShuffleJSON)
+	if err != nil {
+		return false, err
+	}
+
+	var target *QuestionEvaluation
+	for i := range evaluation.Sections {
+		if evaluation.Sections[i].SectionID != sectionID {
+			continue
+		}
+		for j := range evaluation.Sections[i].Questions {
+			if evaluation.Sections[i].Questions[j].QuestionNumber == q.QuestionNumber {
+				target = &evaluation.Sections[i].Questions[j]
+			}
+		}
+	}
+	if target == nil {
+		return false, nil
+	}
+
+	a := answerByQuestion[sectionID][q.QuestionNumber]
+	qEval := scoreQuestion(q, a)
+	if qEval.Status == "pending" {
+		// Same as a full re-grade, manual and AI grades are kept
+		if target.Status == "graded" || target.Status == "ai-graded" {
+			qEval = *target
+		} else {
+			applyOpenEndedGrader(q, a, &qEval)
+		}
+	}
+	*target = qEval
+	evaluation.recomputeTotals()
+
+	evaluationJSONBytes, err := json.Marshal(evaluation)
+	if err != nil {
+		return false, err
+	}
+	attempt.EvaluationJSON = string(evaluationJSONBytes)
+	attempt.AchievedMarks = evaluation.AchievedMarks
+	return true, nil
+}
@@ -0,0 +1,419 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/Qubitopia/quantum-scholar-backend/content"
+	"github.com/Qubitopia/quantum-scholar-backend/database"
+	"github.com/Qubitopia/quantum-scholar-backend/grader"
+	"github.com/Qubitopia/quantum-scholar-backend/mail"
+	"github.com/Qubitopia/quantum-scholar-backend/models"
+	"github.com/Qubitopia/quantum-scholar-backend/runner"
+	"github.com/gin-gonic/gin"
+)
+
+type ResultsSettingsRequest struct {
+	ResultsRelease     string `json:"results_release" binding:"required,oneof=immediate scheduled manual"`
+	ResultsReleaseTime string `json:"results_release_time"` // RFC3339, required for scheduled release
+	ShowCorrectAnswers bool   `json:"show_correct_answers"`
+	EmailResults       bool   `json:"email_results"`
+}
+
+type CandidateResultRequest struct {
+	Email     string `json:"email" binding:"required,email"`
+	Token     string `json:"token" binding:"required"`
+	AttemptId uint32 `json:"attempt_id" binding:"required"`
+}
+
+type SectionResult struct {
+	SectionID int     `json:"section_id"`
+	Title     string  `json:"title"`
+	Marks     float64 `json:"marks"`
+	MaxMarks  float64 `json:"max_marks"`
+}
+
+type QuestionResult struct {
+	SectionID       int                     `json:"section_id"`
+	QuestionNumber  int                     `json:"question_number"`
+	Type            string                  `json:"type"`
+	QuestionText    string                  `json:"question_text"`
+	QuestionHTML    string                  `json:"question_html"` // safe to insert into the page, math is left for KaTeX
+	Options         []string                `json:"options,omitempty"`
+	OptionsHTML     []string                `json:"options_html,omitempty"`
+	YourAnswer      *Answer                 `json:"your_answer,omitempty"`
+	CorrectOption   int                     `json:"correct_option,omitempty"`
+	CorrectOptions  []int                   `json:"correct_options,omitempty"`
+	ModelAnswer     string                  `json:"model_answer,omitempty"`
+	ModelAnswerHTML string                  `json:"model_answer_html,omitempty"`
+	NumericAnswer   *float64                `json:"numeric_answer,omitempty"`
+	Tolerance       float64                 `json:"tolerance,omitempty"`
+	ToleranceType   string                  `json:"tolerance_type,omitempty"`
+	MinValue        *float64                `json:"min_value,omitempty"`
+	MaxValue        *float64                `json:"max_value,omitempty"`
+	Units           string                  `json:"units,omitempty"`
+	Items           []string                `json:"items,omitempty"` // order questions list them in the correct order
+	MatchOptions    []string                `json:"match_options,omitempty"`
+	CorrectMatches  []int                   `json:"correct_matches,omitempty"`
+	Blanks          []Blank                 `json:"blanks,omitempty"`
+	Language        string                  `json:"language,omitempty"`
+	TestCases       []runner.TestCase       `json:"test_cases,omitempty"` // visible ones only
+	TestCaseResults []runner.TestCaseResult `json:"test_case_results,omitempty"`
+	Explanation     string                  `json:"explanation,omitempty"`
+	Status          string                  `json:"status"`
+	Marks           float64                 `json:"marks"`
+	MaxMarks        float64                 `json:"max_marks"`
+	Comment         string                  `json:"comment,omitempty"`
+	CriterionScores []grader.CriterionScore `json:"criterion_scores,omitempty"`
+}
+
+// UpdateResultsSettings sets how and when candidates get to see their results
+func UpdateResultsSettings(c *gin.Context) {
+	test, ok := getOwnedTest(c)
+	if !ok {
+		return
+	}
+
+	var req ResultsSettingsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	test.ResultsRelease = req.ResultsRelease
+	test.ShowCorrectAnswers = req.ShowCorrectAnswers
+	test.EmailResults = req.EmailResults
+	test.ResultsReleaseTime = time.Time{}
+	if req.ResultsRelease == "scheduled" {
+		releaseTime, err := time.Parse(time.RFC3339, req.ResultsReleaseTime)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid results_release_time format. Use RFC3339 format."})
+			return
+		}
+		test.ResultsReleaseTime = releaseTime
+	}
+
+	if err := database.DB.Save(&test).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update results settings"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Results settings updated successfully"})
+}
+
+// ReleaseResults releases the results of a test right away
+func ReleaseResults(c *gin.Context) {
+	test, ok := getOwnedTest(c)
+	if !ok {
+		return
+	}
+	if test.Status == "draft" {
+		c.JSON(http.StatusConflict, gin.H{"error": "Results cannot be released for a draft test"})
+		return
+	}
+	if resultsReleased(test, time.Now()) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Results have already been released"})
+		return
+	}
+
+	test.ResultsRelease = "manual"
+	test.ResultsReleasedAt = time.Now()
+	// Conditional so a scheduled release sweeping at the same moment does not email twice
+	result := database.DB.Model(&models.Test{}).
+		Where("test_id = ? AND results_released_at <= ?", test.TestID, time.Time{}).
+		Updates(map[string]interface{}{
+			"results_release":     test.ResultsRelease,
+			"results_released_at": test.ResultsReleasedAt,
+		})
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to release results"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Results have already been released"})
+		return
+	}
+
+	var attempts []models.AnswerAttempt
+	if err := database.DB.Where("test_id = ? AND status = ? AND grading_status = ?", test.TestID, "submitted", "graded").Find(&attempts).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Results released but failed to fetch attempts"})
+		return
+	}
+	go func() {
+		if test.EmailResults {
+			emailResults(test, attempts)
+		}
+		issueCredentials(test, attempts)
+	}()
+
+	c.JSON(http.StatusOK, gin.H{"message": "Results released successfully"})
+}
+
+// GetCandidateResult returns a candidate's score for a submitted attempt once results are released
+func GetCandidateResult(c *gin.Context) {
+	var req CandidateResultRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	// Check if token is validon redis for the email, if yes then proceed
+	storedToken, err := database.RedisClient.Get(context.Background(), "email:"+req.Email).Result()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve token from Redis"})
+		return
+	}
+
+	if storedToken != req.Token {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+		return
+	}
+
+	// Bump the expiry by next 15 minutes
+	if err := database.RedisClient.Expire(context.Background(), "email:"+req.Email, 15*time.Minute).Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to extend token expiry"})
+		return
+	}
+
+	// Check if the attempt exists and belongs to the candidate
+	var candidate models.User
+	if err := database.DB.Where("email = ?", req.Email).First(&candidate).Error; err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+	var attempt models.AnswerAttempt
+	if err := database.DB.Where("answer_id = ? AND candidate_id = ?", req.AttemptId, candidate.ID).First(&attempt).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Attempt not found"})
+		return
+	}
+	if attempt.Status != "submitted" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Test attempt has not been submitted"})
+		return
+	}
+
+	var test models.Test
+	if err := database.DB.Where("test_id = ?", attempt.TestID).First(&test).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve test"})
+		return
+	}
+	if !resultsReleased(test, time.Now()) {
+		response := gin.H{"error": "Results have not been released yet", "code": "RESULTS_NOT_RELEASED"}
+		if test.ResultsRelease == "scheduled" {
+			response["results_release_time"] = test.ResultsReleaseTime
+		}
+		c.JSON(http.StatusForbidden, response)
+		return
+	}
+	if attempt.GradingStatus != "graded" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Test attempt is still being graded", "code": "RESULTS_PENDING"})
+		return
+	}
+
+	var tf TestFormat
+	if err := json.Unmarshal([]byte(test.QuestionAnswerJSON), &tf); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid question and answer format"})
+		return
+	}
+	var evaluation Evaluation
+	if err := json.Unmarshal([]byte(attempt.EvaluationJSON), &evaluation); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid evaluation for this attempt"})
+		return
+	}
+
+	titleBySection := map[int]string{}
+	for _, sec := range tf.Sections {
+		titleBySection[sec.SectionID] = sec.Title
+	}
+	sections := make([]SectionResult, 0, len(evaluation.Sections))
+	for _, sec := range evaluation.Sections {
+		sections = append(sections, SectionResult{
+			SectionID: sec.SectionID,
+			Title:     titleBySection[sec.SectionID],
+			Marks:     sec.Marks,
+			MaxMarks:  sec.MaxMarks,
+		})
+	}
+
+	response := gin.H{
+		"test_name":      test.TestName,
+		"achieved_marks": attempt.AchievedMarks,
+		"max_marks":      evaluation.MaxMarks,
+		"pending_review": evaluation.PendingReview,
+		"submit_time":    attempt.SubmitTime,
+		"sections":       sections,
+	}
+
+	// Correct answers and explanations only if the examiner allows it, and not while other
+	// candidates can still answer, results released immediately come out before the test ends
+	if test.ShowCorrectAnswers && !answerKeyReleasable(test, time.Now()) {
+		response["correct_answers_available_at"] = test.TestEndTime.Add(5 * time.Minute)
+	} else if test.ShowCorrectAnswers {
+		keyByQuestion, err := indexAnswerKey(test.QuestionAnswerJSON)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		answerByQuestion, err := indexAnswers(attempt.AnswerJSON, attempt.ShuffleJSON)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		questions := []QuestionResult{}
+		for _, sec := range evaluation.Sections {
+			for _, qEval := range sec.Questions {
+				q := keyByQuestion[sec.SectionID][qEval.QuestionNumber]
+				questions = append(questions, QuestionResult{
+					SectionID:       sec.SectionID,
+					QuestionNumber:  qEval.QuestionNumber,
+					Type:            q.Type,
+					QuestionText:    q.QuestionText,
+					QuestionHTML:    content.RenderHTML(q.TextFormat, q.QuestionText),
+					Options:         q.Options,
+					OptionsHTML:     renderEach(q.OptionsFormat, q.Options),
+					YourAnswer:      answerByQuestion[sec.SectionID][qEval.QuestionNumber],
+					CorrectOption:   q.CorrectOption,
+					CorrectOptions:  q.CorrectOptions,
+					ModelAnswer:     q.ModelAnswer,
+					ModelAnswerHTML: modelAnswerHTML(q),
+					NumericAnswer:   q.NumericAnswer,
+					Tolerance:       q.Tolerance,
+					ToleranceType:   q.ToleranceType,
+					MinValue:        q.MinValue,
+					MaxValue:        q.MaxValue,
+					Units:           q.Units,
+					Items:           q.Items,
+					MatchOptions:    q.MatchOptions,
+					CorrectMatches:  q.CorrectMatches,
+					Blanks:          q.Blanks,
+					Language:        q.Language,
+					TestCases:       visibleTestCases(q.TestCases),
+					TestCaseResults: visibleTestCaseResults(qEval.TestCaseResults),
+					Explanation:     q.Explanation,
+					Status:          qEval.Status,
+					Marks:           qEval.Marks,
+					MaxMarks:        qEval.MaxMarks,
+					Comment:         qEval.Comment,
+					CriterionScores: qEval.CriterionScores,
+				})
+			}
+		}
+		response["questions"] = questions
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// modelAnswerHTML renders the model answer of a question that has one
+func modelAnswerHTML(q Question) string {
+	if q.ModelAnswer == "" {
+		return ""
+	}
+	return content.RenderHTML(q.ModelAnswerFormat, q.ModelAnswer)
+}
+
+// visibleTestCases drops the hidden test cases of a code question
+func visibleTestCases(testCases []runner.TestCase) []runner.TestCase {
+	var visible []runner.TestCase
+	for _, tc := range testCases {
+		if !tc.Hidden {
+			visible = append(visible, tc)
+		}
+	}
+	return visible
+}
+
+// visibleTestCaseResults keeps the outcome of hidden test cases but not the output that could reveal them
+func visibleTestCaseResults(results []runner.TestCaseResult) []runner.TestCaseResult {
+	out := make([]runner.TestCaseResult, len(results))
+	for i, r := range results {
+		if r.Hidden {
+			r.Stdout, r.Stderr = "", ""
+		}
+		out[i] = r
+	}
+	return out
+}
+
+// resultsReleased reports whether candidates may see their results
+func resultsReleased(test models.Test, now time.Time) bool {
+	switch test.ResultsRelease {
+	case "immediate":
+		return true
+	case "scheduled":
+		return !test.ResultsReleaseTime.IsZero() && !now.Before(test.ResultsReleaseTime)
+	default:
+		return !test.ResultsReleasedAt.IsZero()
+	}
+}
+
+// answerKeyReleasable reports whether no candidate can still be answering the test: it is
+// closed, or its end time and the 5 minute grace for saving answers have passed
+func answerKeyReleasable(test models.Test, now time.Time) bool {
+	if test.Status == "closed" || test.Status == "archived" {
+		return true
+	}
+	return now.After(test.TestEndTime.Add(5 * time.Minute))
+}
+
+// releaseScheduledResults marks scheduled results whose time has come as released, emails
+// candidates and issues credentials and certificates. Each test is released once, by whichever
+// sweep first sets its ResultsReleasedAt; attempts graded later are sent theirs by the grader.
+func releaseScheduledResults() {
+	now := time.Now()
+	var tests []models.Test
+	if err := database.DB.
+		Where("results_release = ? AND results_release_time <= ? AND results_released_at <= ?", "scheduled", now, time.Time{}).
+		Find(&tests).Error; err != nil {
+		log.Println("Results release: failed to fetch tests:", err)
+		return
+	}
+
+	for _, test := range tests {
+		if test.ResultsReleaseTime.IsZero() {
+			continue
+		}
+		// Conditional update so only one replica emails the candidates
+		result := database.DB.Model(&models.Test{}).
+			Where("test_id = ? AND results_released_at <= ?", test.TestID, time.Time{}).
+			Update("results_released_at", now)
+		if result.Error != nil || result.RowsAffected == 0 {
+			continue
+		}
+		var attempts []models.AnswerAttempt
+		if err := database.DB.Where("test_id = ? AND status = ? AND grading_status = ?", test.TestID, "submitted", "graded").Find(&attempts).Error; err != nil {
+			log.Printf("Results release: failed to fetch attempts of test %d: %v", test.TestID, err)
+			continue
+		}
+		if test.EmailResults {
+			emailResults(test, attempts)
+		}
+		issueCredentials(test, attempts)
+	}
+}
+
+// emailResults sends every attempt's candidate their score
+func emailResults(test models.Test, attempts []models.AnswerAttempt) {
+	for _, attempt := range attempts {
+		var candidate models.User
+		if err := database.DB.Where("id = ?", attempt.CandidateID).First(&candidate).Error; err != nil {
+			log.Printf("Results email: candidate %d not found", attempt.CandidateID)
+			continue
+		}
+		var evaluation Evaluation
+		if err := json.Unmarshal([]byte(attempt.EvaluationJSON), &evaluation); err != nil {
+			log.Printf("Results email: invalid evaluation for attempt %d", attempt.AnswerID)
+			continue
+		}
+		mail.SendEmailTestResults(
+			candidate.Email,
+			candidate.Name,
+			test.TestName,
+			fmt.Sprintf("%g", attempt.AchievedMarks),
+			fmt.Sprintf("%g", evaluation.MaxMarks),
+			attempt.SubmitTime.UTC().Format(time.RFC1123),
+		)
+	}
+}
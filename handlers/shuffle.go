@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	mrand "math/rand/v2"
+)
+
+// QuestionShuffle records the order the options or items of a question were shown in to one candidate.
+// Items[i] is the answer key number of the item shown at position i+1, same for MatchOptions and Options.
+type QuestionShuffle struct {
+	QuestionNumber int   `json:"questionNumber"`
+	Options        []int `json:"options,omitempty"`
+	Items          []int `json:"items,omitempty"`
+	MatchOptions   []int `json:"matchOptions,omitempty"`
+}
+
+type SectionShuffle struct {
+	SectionID int               `json:"sectionId"`
+	Questions []QuestionShuffle `json:"questions"`
+}
+
+// ShufflePattern format stored in AnswerAttempt.ShuffleJSON, it is never sent to the candidate
+type ShufflePattern struct {
+	Sections []SectionShuffle `json:"sections"`
+}
+
+// paperRand makes every random choice of a candidate's paper. It is seeded per attempt so the
+// paper can be generated again exactly, and ChaCha8 is a fixed algorithm so the same seed keeps
+// giving the same paper across Go releases.
+type paperRand struct {
+	src *mrand.ChaCha8
+}
+
+// newPaperSeed returns a fresh random seed for a paper, hex encoded as stored on the attempt
+func newPaperSeed() (string, error) {
+	seed := make([]byte, 32)
+	if _, err := rand.Read(seed); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(seed), nil
+}
+
+func newPaperRand(seed string) (*paperRand, error) {
+	b, err := hex.DecodeString(seed)
+	if err != nil || len(b) != 32 {
+		return nil, fmt.Errorf("invalid paper seed")
+	}
+	var key [32]byte
+	copy(key[:], b)
+	return &paperRand{src: mrand.NewChaCha8(key)}, nil
+}
+
+// intn returns a uniformly random int in [0, n). Values below 2^64 mod n are rejected so that
+// the modulo does not favour small results.
+func (r *paperRand) intn(n int) int {
+	bound := uint64(n)
+	threshold := -bound % bound
+	for {
+		if v := r.src.Uint64(); v >= threshold {
+			return int(v % bound)
+		}
+	}
+}
+
+// sample picks k distinct indices from [0, n) uniformly at random, in the order they were drawn
+func (r *paperRand) sample(n, k int) []int {
+	indices := make([]int, n)
+	for i := range indices {
+		indices[i] = i
+	}
+	// Fisher-Yates, stopped after k draws
+	for i := 0; i < k && i < n; i++ {
+		j := i + r.intn(n-i)
+		indices[i], indices[j] = indices[j], indices[i]
+	}
+	return indices[:min(k, n)]
+}
+
+// shuffledPositions returns a uniformly random ordering of 1..n
+func (r *paperRand) shuffledPositions(n int) []int {
+	positions := r.sample(n, n)
+	for i := range positions {
+		positions[i]++
+	}
+	return positions
+}
+
+// shuffledOrder is shuffledPositions for order questions, it never returns the correct order
+// since that would give the answer away
+func (r *paperRand) shuffledOrder(n int) []int {
+	for {
+		positions := r.shuffledPositions(n)
+		if n < 2 {
+			return positions
+		}
+		for i, p := range positions {
+			if p != i+1 {
+				return positions
+			}
+		}
+	}
+}
+
+// inShuffledOrder returns the texts in the order given by positions
+func inShuffledOrder(texts []string, positions []int) []string {
+	out := make([]string, len(positions))
+	for i, p := range positions {
+		out[i] = texts[p-1]
+	}
+	return out
+}
+
+// indexShuffle parses AnswerAttempt.ShuffleJSON by section id and question number
+func indexShuffle(shuffleJSON string) (map[int]map[int]QuestionShuffle, error) {
+	var shuffle ShufflePattern
+	if shuffleJSON != "" {
+		if err := json.Unmarshal([]byte(shuffleJSON), &shuffle); err != nil {
+			return nil, fmt.Errorf("invalid shuffle: %w", err)
+		}
+	}
+	shuffleByQuestion := map[int]map[int]QuestionShuffle{}
+	for _, sec := range shuffle.Sections {
+		shuffleByQuestion[sec.SectionID] = map[int]QuestionShuffle{}
+		for _, qs := range sec.Questions {
+			shuffleByQuestion[sec.SectionID][qs.QuestionNumber] = qs
+		}
+	}
+	return shuffleByQuestion, nil
+}
+
+// unshuffleAnswer rewrites an answer given in the positions the candidate saw into answer key
+// numbers. Positions that were never shown become -1 so they cannot match the key.
+func unshuffleAnswer(a *Answer, qs QuestionShuffle) {
+	toKey := func(positions []int, position int) int {
+		if position < 1 || position > len(positions) {
+			return -1
+		}
+		return positions[position-1]
+	}
+
+	if len(qs.Options) > 0 {
+		if a.CorrectOption != nil {
+			o := toKey(qs.Options, *a.CorrectOption)
+			a.CorrectOption = &o
+		}
+		for i, o := range a.CorrectOptions {
+			a.CorrectOptions[i] = toKey(qs.Options, o)
+		}
+	}
+	if len(a.Matches) > 0 && len(qs.Items) > 0 {
+		matches := make([]int, len(qs.Items))
+		for p, o := range a.Matches {
+			if p >= len(qs.Items) {
+				break
+			}
+			if o != 0 && len(qs.MatchOptions) > 0 {
+				o = toKey(qs.MatchOptions, o)
+			}
+			matches[qs.Items[p]-1] = o
+		}
+		a.Matches = matches
+	}
+	if len(a.Order) > 0 && len(qs.Items) > 0 {
+		order := make([]int, len(a.Order))
+		for i, p := range a.Order {
+			order[i] = toKey(qs.Items, p)
+		}
+		a.Order = order
+	}
+}
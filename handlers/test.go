@@ -5,10 +5,14 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"strings"
 	"time"
 
+	"github.com/Qubitopia/quantum-scholar-backend/content"
 	"github.com/Qubitopia/quantum-scholar-backend/database"
+	"github.com/Qubitopia/quantum-scholar-backend/grader"
 	"github.com/Qubitopia/quantum-scholar-backend/models"
+	"github.com/Qubitopia/quantum-scholar-backend/runner"
 	"github.com/gin-gonic/gin"
 )
 
@@ -22,25 +26,70 @@ type CreateNewTestRequest struct {
 }
 
 type Question struct {
-	QuestionNumber int      `json:"questionNumber" binding:"required"`
-	Type           string   `json:"type" binding:"required"`
-	SuccessMarks   int      `json:"successMarks" binding:"required"`
-	FailureMarks   int      `json:"failureMarks" binding:"required"`
-	QuestionText   string   `json:"questionText" binding:"required"`
-	Options        []string `json:"options,omitempty"`
-	CorrectOption  int      `json:"correctOption,omitempty"`
-	CorrectOptions []int    `json:"correctOptions,omitempty"`
-	ModelAnswer    string   `json:"modelAnswer,omitempty"`
+	QuestionNumber    int                `json:"questionNumber" binding:"required"`
+	Type              string             `json:"type" binding:"required"`
+	SuccessMarks      int                `json:"successMarks" binding:"required"`
+	FailureMarks      int                `json:"failureMarks" binding:"required"`
+	QuestionText      string             `json:"questionText" binding:"required"`
+	TextFormat        string             `json:"textFormat,omitempty"` // plain (default), markdown, html or latex, see the content package
+	Options           []string           `json:"options,omitempty"`
+	OptionsFormat     string             `json:"optionsFormat,omitempty"`   // format of options, items and matchOptions
+	Image             string             `json:"image,omitempty"`           // file name from the test's images, shown with the question
+	OptionImages      []string           `json:"optionImages,omitempty"`    // mcq and msq only, one per option, "" for an option without an image
+	KeepOptionOrder   bool               `json:"keepOptionOrder,omitempty"` // mcq and msq only, options are shuffled for every candidate unless set, e.g. for "all of the above"
+	CorrectOption     int                `json:"correctOption,omitempty"`
+	CorrectOptions    []int              `json:"correctOptions,omitempty"`
+	ModelAnswer       string             `json:"modelAnswer,omitempty"`
+	ModelAnswerFormat string             `json:"modelAnswerFormat,omitempty"`
+	Explanation       string             `json:"explanation,omitempty"`    // shown to candidates with their results if the examiner allows it
+	Rubric            []grader.Criterion `json:"rubric,omitempty"`         // open-ended only, points must add up to successMarks
+	ScoringPolicy     string             `json:"scoringPolicy,omitempty"`  // msq only, overrides the section policy
+	NumericAnswer     *float64           `json:"numericAnswer,omitempty"`  // numeric only, the exact value, accepted within the tolerance
+	Tolerance         float64            `json:"tolerance,omitempty"`      // numeric only, zero means the exact value
+	ToleranceType     string             `json:"toleranceType,omitempty"`  // numeric only, absolute (default) or relative to numericAnswer, e.g. 0.02 for 2%
+	MinValue          *float64           `json:"minValue,omitempty"`       // numeric only, accepted range, used instead of numericAnswer
+	MaxValue          *float64           `json:"maxValue,omitempty"`       // numeric only
+	Units             string             `json:"units,omitempty"`          // numeric only, shown to candidates next to the answer box
+	Items             []string           `json:"items,omitempty"`          // match and order only, order lists them in the correct order
+	MatchOptions      []string           `json:"matchOptions,omitempty"`   // match only, what the items are paired with, may include distractors
+	CorrectMatches    []int              `json:"correctMatches,omitempty"` // match only, the matchOption number for each item, numbered from 1
+	Blanks            []Blank            `json:"blanks,omitempty"`         // fill-blank only, blank n is written [[n]] in questionText
+	PartialCredit     bool               `json:"partialCredit,omitempty"`  // match, order and fill-blank only, successMarks scaled by the share of correct pairs, positions or blanks
+	Language          string             `json:"language,omitempty"`       // code only, one the code runner supports, e.g. python
+	StarterCode       string             `json:"starterCode,omitempty"`    // code only
+	TestCases         []runner.TestCase  `json:"testCases,omitempty"`      // code only, successMarks scaled by the share passed
+	TimeLimitMs       int                `json:"timeLimitMs,omitempty"`    // code only, per test case, 2000 when not set
+	MemoryLimitMb     int                `json:"memoryLimitMb,omitempty"`  // code only, 256 when not set
+	Topic             string             `json:"topic,omitempty"`
+	Difficulty        string             `json:"difficulty,omitempty"` // easy, medium or hard
+	Tags              []string           `json:"tags,omitempty"`
+	BankQuestionID    uint32             `json:"bankQuestionId,omitempty"` // set when the question was copied from the question bank
+	BankRule          int                `json:"bankRule,omitempty"`       // the section's bank rule this question was drawn for, numbered from 1
+}
+
+// Blank is one gap of a fill-blank question. Answers are compared after trimming and collapsing
+// whitespace, and ignoring case unless caseSensitive is set.
+type Blank struct {
+	AcceptedAnswers  []string `json:"acceptedAnswers"`
+	CaseSensitive    bool     `json:"caseSensitive,omitempty"`
+	IgnoreDiacritics bool     `json:"ignoreDiacritics,omitempty"` // accept "cafe" for "café"
+	Regex            bool     `json:"regex,omitempty"`            // accepted answers are patterns that must match the whole answer
 }
 type Section struct {
-	SectionID          int        `json:"sectionId" binding:"required"`
-	Title              string     `json:"title" binding:"required"`
-	QuestionsToDisplay int        `json:"questionsToDisplay" binding:"required"`
-	Questions          []Question `json:"questions" binding:"required"`
+	SectionID          int              `json:"sectionId" binding:"required"`
+	Title              string           `json:"title" binding:"required"`
+	QuestionsToDisplay int              `json:"questionsToDisplay" binding:"required"`
+	ScoringPolicy      string           `json:"scoringPolicy,omitempty"` // default for msq questions in this section
+	Questions          []Question       `json:"questions" binding:"required"`
+	BankQuestions      []uint32         `json:"bankQuestions,omitempty"` // question bank ids, copied into questions when the test is published
+	BankRules          []BankRule       `json:"bankRules,omitempty"`     // each adds count questions drawn from the bank for every candidate
+	Blueprint          []BlueprintEntry `json:"blueprint,omitempty"`     // questions per topic and difficulty, replaces random picking of questionsToDisplay
 }
 type TestFormat struct {
-	Title    string    `json:"title" binding:"required"`
-	Sections []Section `json:"sections" binding:"required"`
+	Title            string    `json:"title" binding:"required"`
+	Sections         []Section `json:"sections" binding:"required"`
+	ShuffleQuestions bool      `json:"shuffleQuestions,omitempty"` // questions of each section in a different order for every candidate, the order written otherwise
+	ShuffleSections  bool      `json:"shuffleSections,omitempty"`  // sections in a different order for every candidate
 }
 type UpdateQuestionsAndAnswersInTestRequest struct {
 	TestID             uint32     `json:"test_id" binding:"required"`
@@ -110,6 +159,8 @@ func CreateNewTest(c *gin.Context) {
 		QuestionAnswerJSON:       "{}",
 		Images:                   []string{},
 		QSCoins:                  500,
+		Status:                   "draft",
+		TestActive:               false,
 	}
 	if err := database.DB.Create(&test).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create test"})
@@ -158,11 +209,27 @@ func UpdateQuestionsAndAnswersInTest(c *gin.Context) {
 		return
 	}
 
+	// Questions are locked once the test is published, only the answer key can be corrected
+	if test.Status != "draft" {
+		c.JSON(http.StatusConflict, gin.H{"error": "Questions can only be changed while the test is a draft, use the answer key correction instead"})
+		return
+	}
+
 	// Recursive validation of the JSON structure (Go struct fields)
 	if err := validateTestFormat(req.QuestionAnswerJSON); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if err := validateImageReferences(req.QuestionAnswerJSON, test.Images); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateBankReferences(req.QuestionAnswerJSON, examiner.ID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	// HTML is stored sanitised so nothing unsafe is ever served back
+	sanitiseTestFormat(&req.QuestionAnswerJSON)
 
 	questionAnswerJSONBytes, err := json.Marshal(req.QuestionAnswerJSON)
 	if err != nil {
@@ -174,6 +241,7 @@ func UpdateQuestionsAndAnswersInTest(c *gin.Context) {
 
 	// Update questions and answers in the test
 	test.QuestionAnswerJSON = string(questionAnswerJSONBytes)
+	updatePoolStatistics(&test)
 
 	// Save the updated test to the database
 	if err := database.DB.Save(&test).Error; err != nil {
@@ -192,50 +260,381 @@ func validateTestFormat(tf TestFormat) error {
 	if len(tf.Sections) == 0 {
 		return fmt.Errorf("at least one section is required")
 	}
+	// Answers, shuffles and evaluations are keyed by sectionId and questionNumber
+	sectionIDs := map[int]bool{}
 	for i, section := range tf.Sections {
 		if section.SectionID == 0 {
 			return fmt.Errorf("section %d: sectionId is required", i+1)
 		}
+		if sectionIDs[section.SectionID] {
+			return fmt.Errorf("section %d: sectionId %d is used by another section", i+1, section.SectionID)
+		}
+		sectionIDs[section.SectionID] = true
 		if section.Title == "" {
 			return fmt.Errorf("section %d: title is required", i+1)
 		}
-		if section.QuestionsToDisplay == 0 {
+		// A section made only of bank rules shows what the rules pick
+		if section.QuestionsToDisplay == 0 && len(section.BankRules) == 0 {
 			return fmt.Errorf("section %d: questionsToDisplay is required", i+1)
 		}
-		if len(section.Questions) == 0 {
-			return fmt.Errorf("section %d: at least one question is required", i+1)
+		if len(section.Questions) == 0 && len(section.BankQuestions) == 0 && len(section.BankRules) == 0 {
+			return fmt.Errorf("section %d: at least one question, bank question or bank rule is required", i+1)
+		}
+		if section.ScoringPolicy != "" && !msqScoringPolicies[section.ScoringPolicy] {
+			return fmt.Errorf("section %d: invalid scoringPolicy", i+1)
 		}
+		questionNumbers := map[int]bool{}
 		for j, q := range section.Questions {
 			if q.QuestionNumber == 0 {
 				return fmt.Errorf("section %d, question %d: questionNumber is required", i+1, j+1)
 			}
-			if q.Type != "mcq" && q.Type != "msq" && q.Type != "open-ended" {
-				return fmt.Errorf("section %d, question %d: invalid question type", i+1, j+1)
+			if questionNumbers[q.QuestionNumber] {
+				return fmt.Errorf("section %d, question %d: questionNumber %d is used by another question in this section", i+1, j+1, q.QuestionNumber)
+			}
+			questionNumbers[q.QuestionNumber] = true
+			if err := validateQuestion(q); err != nil {
+				return fmt.Errorf("section %d, question %d: %w", i+1, j+1, err)
+			}
+			if q.BankRule < 0 || q.BankRule > len(section.BankRules) {
+				return fmt.Errorf("section %d, question %d: bankRule %d does not exist in this section", i+1, j+1, q.BankRule)
+			}
+		}
+		if len(section.Blueprint) > 0 {
+			if err := validateBlueprint(section); err != nil {
+				return fmt.Errorf("section %d: %w", i+1, err)
+			}
+		}
+		for k, rule := range section.BankRules {
+			if err := validateBankRule(rule); err != nil {
+				return fmt.Errorf("section %d, bank rule %d: %w", i+1, k+1, err)
+			}
+		}
+	}
+	return nil
+}
+
+var questionTypes = map[string]bool{
+	"mcq": true, "msq": true, "open-ended": true, "numeric": true,
+	"match": true, "order": true, "fill-blank": true, "code": true,
+}
+
+// validateQuestion checks a single question, wherever it is kept, apart from its number
+func validateQuestion(q Question) error {
+	if !questionTypes[q.Type] {
+		return fmt.Errorf("invalid question type")
+	}
+	if q.QuestionText == "" {
+		return fmt.Errorf("questionText is required")
+	}
+	if q.SuccessMarks <= 0 {
+		return fmt.Errorf("successMarks is required")
+	}
+	if q.FailureMarks > 0 {
+		return fmt.Errorf("failureMarks should be zero or negative")
+	}
+	// log.Println(len(q.Options))
+	if q.Type == "mcq" && (len(q.Options) < 2 || (q.CorrectOption < 1 || q.CorrectOption > len(q.Options))) {
+		return fmt.Errorf("mcq type requires at least 2 options and a correct option")
+	}
+	if q.Type == "msq" && (len(q.Options) < 2 || len(q.CorrectOptions) == 0) {
+		return fmt.Errorf("msq type requires at least 2 options and at least one correct option")
+	}
+	if q.Type == "msq" {
+		// Options are numbered from 1, same as correctOption for mcq
+		for _, o := range q.CorrectOptions {
+			if o < 1 || o > len(q.Options) {
+				return fmt.Errorf("msq correct options must be between 1 and %d", len(q.Options))
+			}
+		}
+	}
+	if q.ScoringPolicy != "" && (q.Type != "msq" || !msqScoringPolicies[q.ScoringPolicy]) {
+		return fmt.Errorf("scoringPolicy must be one of all-or-nothing, proportional, proportional-penalty or any-wrong-fails and is only allowed on msq")
+	}
+	if q.Type == "open-ended" && q.ModelAnswer == "" {
+		return fmt.Errorf("open-ended type requires a model answer")
+	}
+	if q.KeepOptionOrder && q.Type != "mcq" && q.Type != "msq" {
+		return fmt.Errorf("keepOptionOrder is only allowed on mcq and msq questions")
+	}
+	if q.Difficulty != "" && !difficultyLevels[q.Difficulty] {
+		return fmt.Errorf("difficulty must be easy, medium or hard")
+	}
+	if len(q.Rubric) > 0 {
+		if err := validateRubric(q); err != nil {
+			return err
+		}
+	}
+	if err := validateNumeric(q); err != nil {
+		return err
+	}
+	if err := validateItems(q); err != nil {
+		return err
+	}
+	if err := validateBlanks(q); err != nil {
+		return err
+	}
+	if err := validateCode(q); err != nil {
+		return err
+	}
+	return validateContent(q)
+}
+
+// validateContent checks each text field of a question against the format declared for it
+func validateContent(q Question) error {
+	if err := content.Validate(q.TextFormat, q.QuestionText); err != nil {
+		return fmt.Errorf("questionText: %w", err)
+	}
+	for _, list := range [][]string{q.Options, q.Items, q.MatchOptions} {
+		for k, text := range list {
+			if err := content.Validate(q.OptionsFormat, text); err != nil {
+				return fmt.Errorf("option %d: %w", k+1, err)
+			}
+		}
+	}
+	if err := content.Validate(q.ModelAnswerFormat, q.ModelAnswer); err != nil {
+		return fmt.Errorf("modelAnswer: %w", err)
+	}
+	return nil
+}
+
+// sanitiseTestFormat replaces every HTML field with its sanitised form, the test must have been validated
+func sanitiseTestFormat(tf *TestFormat) {
+	for i := range tf.Sections {
+		for j := range tf.Sections[i].Questions {
+			sanitiseQuestion(&tf.Sections[i].Questions[j])
+		}
+	}
+}
+
+// sanitiseQuestion replaces the HTML fields of a validated question with their sanitised form
+func sanitiseQuestion(q *Question) {
+	q.QuestionText, _ = content.Clean(q.TextFormat, q.QuestionText)
+	for _, list := range [][]string{q.Options, q.Items, q.MatchOptions} {
+		for k := range list {
+			list[k], _ = content.Clean(q.OptionsFormat, list[k])
+		}
+	}
+	q.ModelAnswer, _ = content.Clean(q.ModelAnswerFormat, q.ModelAnswer)
+}
+
+// renderEach renders every text of a list in the same format
+func renderEach(format string, texts []string) []string {
+	if len(texts) == 0 {
+		return nil
+	}
+	rendered := make([]string, len(texts))
+	for k, text := range texts {
+		rendered[k] = content.RenderHTML(format, text)
+	}
+	return rendered
+}
+
+// validateImageReferences checks that every image a question or option points to was uploaded to the test
+func validateImageReferences(tf TestFormat, images []string) error {
+	uploaded := map[string]bool{}
+	for _, name := range images {
+		uploaded[name] = true
+	}
+	for i, section := range tf.Sections {
+		for j, q := range section.Questions {
+			if q.Image != "" && !uploaded[q.Image] {
+				return fmt.Errorf("section %d, question %d: image %q has not been uploaded to this test", i+1, j+1, q.Image)
+			}
+			if len(q.OptionImages) == 0 {
+				continue
 			}
-			if q.QuestionText == "" {
-				return fmt.Errorf("section %d, question %d: questionText is required", i+1, j+1)
+			if q.Type != "mcq" && q.Type != "msq" {
+				return fmt.Errorf("section %d, question %d: optionImages are only allowed on mcq and msq questions", i+1, j+1)
 			}
-			if q.SuccessMarks <= 0 {
-				return fmt.Errorf("section %d, question %d: successMarks is required", i+1, j+1)
+			if len(q.OptionImages) != len(q.Options) {
+				return fmt.Errorf("section %d, question %d: optionImages must have one entry per option", i+1, j+1)
 			}
-			if q.FailureMarks > 0 {
-				return fmt.Errorf("section %d, question %d: failureMarks should be zero or negative", i+1, j+1)
+			for k, name := range q.OptionImages {
+				if name != "" && !uploaded[name] {
+					return fmt.Errorf("section %d, question %d, option %d: image %q has not been uploaded to this test", i+1, j+1, k+1, name)
+				}
 			}
-			// log.Println(len(q.Options))
-			if q.Type == "mcq" && (len(q.Options) < 2 || (q.CorrectOption < 1 || q.CorrectOption > len(q.Options))) {
-				return fmt.Errorf("section %d, question %d: mcq type requires at least 2 options and a correct option", i+1, j+1)
+		}
+	}
+	return nil
+}
+
+// imageReferencedBy returns where an image is used in the questions, or "" if it is not
+func imageReferencedBy(tf TestFormat, name string) string {
+	for _, section := range tf.Sections {
+		for _, q := range section.Questions {
+			if q.Image == name {
+				return fmt.Sprintf("section %d, question %d", section.SectionID, q.QuestionNumber)
 			}
-			if q.Type == "msq" && (len(q.Options) < 2 || len(q.CorrectOptions) == 0) {
-				return fmt.Errorf("section %d, question %d: msq type requires at least 2 options and at least one correct option", i+1, j+1)
+			for k, optionImage := range q.OptionImages {
+				if optionImage == name {
+					return fmt.Sprintf("section %d, question %d, option %d", section.SectionID, q.QuestionNumber, k+1)
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// validateNumeric checks that a numeric question has either an exact value or a range, and that
+// only numeric questions carry numeric answer key fields
+func validateNumeric(q Question) error {
+	hasRange := q.MinValue != nil || q.MaxValue != nil
+	if q.Type != "numeric" {
+		if q.NumericAnswer != nil || hasRange || q.Tolerance != 0 || q.ToleranceType != "" || q.Units != "" {
+			return fmt.Errorf("numericAnswer, tolerance, toleranceType, minValue, maxValue and units are only allowed on numeric questions")
+		}
+		return nil
+	}
+	if q.NumericAnswer == nil && !hasRange {
+		return fmt.Errorf("numeric type requires a numericAnswer or a minValue and maxValue")
+	}
+	if q.NumericAnswer != nil && hasRange {
+		return fmt.Errorf("numeric type takes either a numericAnswer or a range, not both")
+	}
+	if hasRange {
+		if q.MinValue == nil || q.MaxValue == nil {
+			return fmt.Errorf("numeric range requires both minValue and maxValue")
+		}
+		if *q.MinValue > *q.MaxValue {
+			return fmt.Errorf("numeric range minValue must not be greater than maxValue")
+		}
+		if q.Tolerance != 0 || q.ToleranceType != "" {
+			return fmt.Errorf("tolerance is not used with a numeric range")
+		}
+	}
+	if q.Tolerance < 0 {
+		return fmt.Errorf("tolerance must not be negative")
+	}
+	if q.ToleranceType != "" && q.ToleranceType != "absolute" && q.ToleranceType != "relative" {
+		return fmt.Errorf("toleranceType must be absolute or relative")
+	}
+	return nil
+}
+
+// validateItems checks the items of match and order questions and that only they carry them
+func validateItems(q Question) error {
+	if q.Type != "match" && q.Type != "order" {
+		if len(q.Items) > 0 || len(q.MatchOptions) > 0 || len(q.CorrectMatches) > 0 {
+			return fmt.Errorf("items are only allowed on match and order questions, matchOptions and correctMatches only on match")
+		}
+		if q.PartialCredit && q.Type != "fill-blank" {
+			return fmt.Errorf("partialCredit is only allowed on match, order and fill-blank questions")
+		}
+		return nil
+	}
+	if len(q.Items) < 2 {
+		return fmt.Errorf("%s type requires at least 2 items", q.Type)
+	}
+	if q.Type == "order" {
+		if len(q.MatchOptions) > 0 || len(q.CorrectMatches) > 0 {
+			return fmt.Errorf("matchOptions and correctMatches are only allowed on match questions")
+		}
+		return nil
+	}
+	if len(q.MatchOptions) < 2 {
+		return fmt.Errorf("match type requires at least 2 matchOptions")
+	}
+	if len(q.CorrectMatches) != len(q.Items) {
+		return fmt.Errorf("match type requires one correctMatches entry per item")
+	}
+	for _, o := range q.CorrectMatches {
+		if o < 1 || o > len(q.MatchOptions) {
+			return fmt.Errorf("match correct matches must be between 1 and %d", len(q.MatchOptions))
+		}
+	}
+	return nil
+}
+
+// validateBlanks checks that every blank of a fill-blank question has accepted answers, that
+// regex answers compile and that each blank is marked in the question text
+func validateBlanks(q Question) error {
+	if q.Type != "fill-blank" {
+		if len(q.Blanks) > 0 {
+			return fmt.Errorf("blanks are only allowed on fill-blank questions")
+		}
+		return nil
+	}
+	if len(q.Blanks) == 0 {
+		return fmt.Errorf("fill-blank type requires at least one blank")
+	}
+	for k, blank := range q.Blanks {
+		if !strings.Contains(q.QuestionText, blankPlaceholder(k+1)) {
+			return fmt.Errorf("blank %d: questionText must contain %s", k+1, blankPlaceholder(k+1))
+		}
+		if len(blank.AcceptedAnswers) == 0 {
+			return fmt.Errorf("blank %d: at least one accepted answer is required", k+1)
+		}
+		for _, accepted := range blank.AcceptedAnswers {
+			if strings.TrimSpace(accepted) == "" {
+				return fmt.Errorf("blank %d: accepted answers must not be empty", k+1)
 			}
-			if q.Type == "open-ended" && q.ModelAnswer == "" {
-				return fmt.Errorf("section %d, question %d: open-ended type requires a model answer", i+1, j+1)
+			if blank.Regex {
+				if _, err := blankPattern(accepted, blank); err != nil {
+					return fmt.Errorf("blank %d: invalid regex %q: %w", k+1, accepted, err)
+				}
 			}
 		}
 	}
 	return nil
 }
 
+// validateCode checks that a code question is in a language this server can run, has test
+// cases and asks for limits within the runner's caps
+func validateCode(q Question) error {
+	if q.Type != "code" {
+		if q.Language != "" || q.StarterCode != "" || len(q.TestCases) > 0 || q.TimeLimitMs != 0 || q.MemoryLimitMb != 0 {
+			return fmt.Errorf("language, starterCode, testCases, timeLimitMs and memoryLimitMb are only allowed on code questions")
+		}
+		return nil
+	}
+	if runner.CodeRunner == nil || !runner.CodeRunner.Supports(q.Language) {
+		return fmt.Errorf("language %q is not supported by the code runner", q.Language)
+	}
+	if len(q.TestCases) == 0 {
+		return fmt.Errorf("code type requires at least one test case")
+	}
+	if len(q.TestCases) > maxCodeTestCases {
+		return fmt.Errorf("code type allows at most %d test cases", maxCodeTestCases)
+	}
+	if q.TimeLimitMs < 0 || time.Duration(q.TimeLimitMs)*time.Millisecond > runner.MaxTimeout {
+		return fmt.Errorf("timeLimitMs must be between 1 and %d, or 0 for the default", runner.MaxTimeout.Milliseconds())
+	}
+	if q.MemoryLimitMb < 0 || int64(q.MemoryLimitMb)<<20 > runner.MaxMemoryBytes {
+		return fmt.Errorf("memoryLimitMb must be between 1 and %d, or 0 for the default", runner.MaxMemoryBytes>>20)
+	}
+	return nil
+}
+
+// validateRubric checks that every criterion is identified, described and worth points adding up to successMarks
+func validateRubric(q Question) error {
+	if q.Type != "open-ended" {
+		return fmt.Errorf("rubric is only allowed on open-ended questions")
+	}
+	ids := map[string]bool{}
+	total := 0.0
+	for k, criterion := range q.Rubric {
+		if criterion.ID == "" {
+			return fmt.Errorf("rubric criterion %d: id is required", k+1)
+		}
+		if ids[criterion.ID] {
+			return fmt.Errorf("rubric criterion %d: id %q is used more than once", k+1, criterion.ID)
+		}
+		ids[criterion.ID] = true
+		if criterion.Description == "" {
+			return fmt.Errorf("rubric criterion %d: description is required", k+1)
+		}
+		if criterion.Points <= 0 {
+			return fmt.Errorf("rubric criterion %d: points must be positive", k+1)
+		}
+		total += criterion.Points
+	}
+	if total != float64(q.SuccessMarks) {
+		return fmt.Errorf("rubric points add up to %g but successMarks is %d", total, q.SuccessMarks)
+	}
+	return nil
+}
+
 func GetAllTestsCreatedByUser(c *gin.Context) {
 	user, exists := c.Get("user")
 	if !exists {
@@ -328,6 +727,12 @@ func AddCandidatesToTest(c *gin.Context) {
 		return
 	}
 
+	// Candidates can only be invited to published tests
+	if test.Status != "published" {
+		c.JSON(http.StatusConflict, gin.H{"error": "Candidates can only be added to a published test"})
+		return
+	}
+
 	// Optimization: Batch DB calls for users and assignments
 	candidateEmails := req.CandidateEmails
 	if len(candidateEmails) == 0 {
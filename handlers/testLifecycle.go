@@ -0,0 +1,202 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Qubitopia/quantum-scholar-backend/database"
+	"github.com/Qubitopia/quantum-scholar-backend/models"
+	"github.com/gin-gonic/gin"
+)
+
+// Test lifecycle: draft -> published -> closed -> archived
+// Maps each status to the only status it can be reached from.
+var testStatusPredecessor = map[string]string{
+	"published": "draft",
+	"closed":    "published",
+	"archived":  "closed",
+}
+
+// PublishTest locks the questions and opens the test for candidates
+func PublishTest(c *gin.Context) {
+	test, ok := getOwnedTestForTransition(c, "published")
+	if !ok {
+		return
+	}
+
+	// Bank questions are copied in so later edits to the bank do not change the published test
+	if err := resolveTestBankReferences(&test); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	updatePoolStatistics(&test)
+	if err := validateTestForPublish(test); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	test.Status = "published"
+	test.TestActive = true
+	if err := database.DB.Save(&test).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to publish test"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Test published successfully", "status": test.Status})
+}
+
+// CloseTest stops all candidate activity, attempts still in progress are auto-submitted and
+// attempts never started are abandoned
+func CloseTest(c *gin.Context) {
+	test, ok := getOwnedTestForTransition(c, "closed")
+	if !ok {
+		return
+	}
+
+	// The test and its attempts change together so no attempt is left open on a closed test
+	tx := database.DB.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	test.Status = "closed"
+	test.TestActive = false
+	if err := tx.Save(&test).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to close test"})
+		return
+	}
+
+	// Submitted attempts are graded by the grading workers on their next sweep
+	submitted := tx.Model(&models.AnswerAttempt{}).
+		Where("test_id = ? AND status = ?", test.TestID, "in-progress").
+		Updates(submitUpdates(time.Now(), true))
+	if submitted.Error != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to submit attempts in progress"})
+		return
+	}
+	abandoned := tx.Model(&models.AnswerAttempt{}).
+		Where("test_id = ? AND status = ?", test.TestID, "initialized").
+		Update("status", "abandoned")
+	if abandoned.Error != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to abandon attempts not started"})
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to close test"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":                 "Test closed successfully",
+		"status":                  test.Status,
+		"attempts_auto_submitted": submitted.RowsAffected,
+		"attempts_abandoned":      abandoned.RowsAffected,
+	})
+}
+
+// ArchiveTest moves a closed test out of active use
+func ArchiveTest(c *gin.Context) {
+	test, ok := getOwnedTestForTransition(c, "archived")
+	if !ok {
+		return
+	}
+
+	test.Status = "archived"
+	test.TestActive = false
+	if err := database.DB.Save(&test).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to archive test"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Test archived successfully", "status": test.Status})
+}
+
+// getOwnedTestForTransition loads the test in the :id param owned by the user and checks
+// that it can move to the target status. On failure the response is already written.
+func getOwnedTestForTransition(c *gin.Context, target string) (models.Test, bool) {
+	test, ok := getOwnedTest(c)
+	if !ok {
+		return test, false
+	}
+
+	if test.Status != testStatusPredecessor[target] {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Test cannot move from %s to %s", test.Status, target)})
+		return test, false
+	}
+
+	return test, true
+}
+
+// getOwnedTest loads the test in the :id param and checks that the user owns it.
+// On failure the response is already written.
+func getOwnedTest(c *gin.Context) (models.Test, bool) {
+	var test models.Test
+
+	user, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found in context"})
+		return test, false
+	}
+
+	examiner, ok := user.(models.User)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user from context"})
+		return test, false
+	}
+
+	testIDParam := c.Param("id")
+	var testID uint32
+	if _, err := fmt.Sscanf(testIDParam, "%d", &testID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid test id"})
+		return test, false
+	}
+
+	if err := database.DB.First(&test, testID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Test not found"})
+		return test, false
+	}
+
+	if test.ExaminerID != examiner.ID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You are not the owner of this test"})
+		return test, false
+	}
+
+	return test, true
+}
+
+// validateTestForPublish checks that the question JSON is complete and the schedule is usable
+func validateTestForPublish(test models.Test) error {
+	var tf TestFormat
+	if err := json.Unmarshal([]byte(test.QuestionAnswerJSON), &tf); err != nil {
+		return fmt.Errorf("invalid question and answer format")
+	}
+	if err := validateTestFormat(tf); err != nil {
+		return fmt.Errorf("questions are incomplete: %w", err)
+	}
+	if err := validateImageReferences(tf, test.Images); err != nil {
+		return err
+	}
+	for i, section := range tf.Sections {
+		if len(section.BankQuestions) > 0 {
+			return fmt.Errorf("section %d: bank questions have not been copied into the test", i+1)
+		}
+		if err := validateSectionPools(section); err != nil {
+			return fmt.Errorf("section %d: %w", i+1, err)
+		}
+	}
+	if !test.TestEndTime.After(test.TestStartTime) {
+		return fmt.Errorf("test_end_time must be after test_start_time")
+	}
+	if !test.TestEndTime.After(time.Now()) {
+		return fmt.Errorf("test_end_time is already in the past")
+	}
+	return nil
+}
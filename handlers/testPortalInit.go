@@ -5,13 +5,17 @@ import (
 	"crypto/rand"
 	"encoding/base64"
 	"encoding/json"
+	"fmt"
 
 	// "log"
 	"net/http"
+	"sort"
 	"time"
 
+	"github.com/Qubitopia/quantum-scholar-backend/content"
 	"github.com/Qubitopia/quantum-scholar-backend/database"
 	"github.com/Qubitopia/quantum-scholar-backend/models"
+	"github.com/Qubitopia/quantum-scholar-backend/runner"
 
 	"github.com/gin-gonic/gin"
 )
@@ -40,33 +44,38 @@ type StartTestAttemptRequest struct {
 	AttemptID uint32 `json:"attempt_id" binding:"required"`
 }
 
+type Answer struct {
+	QuestionNumber int      `json:"questionNumber"`
+	CorrectOption  *int     `json:"CorrectOption,omitempty"`
+	CorrectOptions []int    `json:"CorrectOptions,omitempty"`
+	Answer         *string  `json:"answer,omitempty"`
+	NumericAnswer  *float64 `json:"numericAnswer,omitempty"`
+	Matches        []int    `json:"matches,omitempty"` // match only, for each item in the order shown the matchOption picked, 0 if none
+	Order          []int    `json:"order,omitempty"`   // order only, the items in the order shown, arranged by the candidate
+	Blanks         []string `json:"blanks,omitempty"`  // fill-blank only, one entry per blank in order
+	Code           *string  `json:"code,omitempty"`    // code only, the full source
+}
+
+type SectionAnswers struct {
+	SectionId int      `json:"sectionId"`
+	Answers   []Answer `json:"answers"`
+}
+
 type AnswerPattern struct {
-	Sections []struct {
-		SectionId int `json:"sectionId"`
-		Answers   []struct {
-			QuestionNumber int     `json:"questionNumber"`
-			CorrectOption  *int    `json:"CorrectOption,omitempty"`
-			CorrectOptions []int   `json:"CorrectOptions,omitempty"`
-			Answer         *string `json:"answer,omitempty"`
-		} `json:"answers"`
-	} `json:"sections"`
+	Sections []SectionAnswers `json:"sections"`
 }
 
-type UpdateTestAttemptRequest struct {
+type SubmitTestAttemptRequest struct {
 	Email     string `json:"email" binding:"required,email"`
 	Token     string `json:"token" binding:"required"`
 	AttemptId uint32 `json:"attempt_id" binding:"required"`
-	Answer    struct {
-		Sections []struct {
-			SectionId int `json:"sectionId"`
-			Answers   []struct {
-				QuestionNumber int     `json:"questionNumber"`
-				CorrectOption  *int    `json:"CorrectOption,omitempty"`
-				CorrectOptions []int   `json:"CorrectOptions,omitempty"`
-				Answer         *string `json:"answer,omitempty"`
-			} `json:"answers"`
-		} `json:"sections"`
-	} `json:"answer" binding:"required"`
+}
+
+type UpdateTestAttemptRequest struct {
+	Email     string        `json:"email" binding:"required,email"`
+	Token     string        `json:"token" binding:"required"`
+	AttemptId uint32        `json:"attempt_id" binding:"required"`
+	Answer    AnswerPattern `json:"answer" binding:"required"`
 }
 
 // APIs for testing portal
@@ -124,6 +133,7 @@ func TestPortalLogin(c *gin.Context) {
 		TestName      string    `json:"test_name"`
 		TestStartTime time.Time `json:"test_start_time"`
 		TestEndTime   time.Time `json:"test_end_time"`
+		Status        string    `json:"status"`
 	}
 
 	// Prepare test info list
@@ -140,6 +150,7 @@ func TestPortalLogin(c *gin.Context) {
 			TestName:      test.TestName,
 			TestStartTime: test.TestStartTime,
 			TestEndTime:   test.TestEndTime,
+			Status:        testWindowStatus(test, time.Now()),
 		})
 	}
 
@@ -185,37 +196,101 @@ func CreateQuestionAnswerJSON(test_id uint32, candidate_id uint32) (uint32, erro
 		return 0, err
 	}
 
-	// 2) Define structures matching stored Test.QuestionAnswerJSON (examiner view)
+	// 2) Generate the paper from a fresh seed, kept on the attempt so the paper can be generated again
+	seed, err := newPaperSeed()
+	if err != nil {
+		return 0, err
+	}
+	questionJSON, shuffleJSON, err := generatePaper(test, seed)
+	if err != nil {
+		return 0, err
+	}
+
+	// 3) Store in AnswerAttempt table
+	attempt := models.AnswerAttempt{
+		TestID:         test_id,
+		CandidateID:    candidate_id,
+		StartTime:      time.Time{},
+		Duration:       test.TestDuration,
+		Status:         "initialized",
+		QuestionJSON:   questionJSON,
+		AnswerJSON:     "{}",
+		EvaluationJSON: "{}",
+		ShuffleJSON:    shuffleJSON,
+		PaperSeed:      seed,
+		AchievedMarks:  0,
+	}
+
+	if err := database.DB.Create(&attempt).Error; err != nil {
+		return 0, err
+	}
+
+	return uint32(attempt.AnswerID), nil
+}
+
+// generatePaper builds a candidate's questions from the test and the order their items are shown
+// in. Every random choice comes from the seed, the same test and seed always give the same paper.
+func generatePaper(test models.Test, seed string) (string, string, error) {
+	rng, err := newPaperRand(seed)
+	if err != nil {
+		return "", "", err
+	}
+
+	// 1) Define structures matching stored Test.QuestionAnswerJSON (examiner view)
 	type storedQuestion struct {
-		QuestionNumber int      `json:"questionNumber"`
-		Type           string   `json:"type"`
-		SuccessMarks   int      `json:"successMarks"`
-		FailureMarks   int      `json:"failureMarks"`
-		QuestionText   string   `json:"questionText"`
-		Options        []string `json:"options,omitempty"`
-		// Fields to be omitted in candidate view
-		CorrectOption  *int   `json:"correctOption,omitempty"`
-		CorrectOptions []int  `json:"correctOptions,omitempty"`
-		ModelAnswer    string `json:"modelAnswer,omitempty"`
+		QuestionNumber  int      `json:"questionNumber"`
+		Type            string   `json:"type"`
+		SuccessMarks    int      `json:"successMarks"`
+		FailureMarks    int      `json:"failureMarks"`
+		QuestionText    string   `json:"questionText"`
+		TextFormat      string   `json:"textFormat,omitempty"`
+		Options         []string `json:"options,omitempty"`
+		OptionsFormat   string   `json:"optionsFormat,omitempty"`
+		Image           string   `json:"image,omitempty"`
+		OptionImages    []string `json:"optionImages,omitempty"`
+		KeepOptionOrder bool     `json:"keepOptionOrder,omitempty"`
+		Units           string   `json:"units,omitempty"`
+		Items           []string `json:"items,omitempty"`
+		MatchOptions    []string `json:"matchOptions,omitempty"`
+		PartialCredit   bool     `json:"partialCredit,omitempty"`
+		Language        string   `json:"language,omitempty"`
+		StarterCode     string   `json:"starterCode,omitempty"`
+		TimeLimitMs     int      `json:"timeLimitMs,omitempty"`
+		MemoryLimitMb   int      `json:"memoryLimitMb,omitempty"`
+		// Fields to be omitted in candidate view, hidden test cases included
+		Blanks         []Blank           `json:"blanks,omitempty"`
+		TestCases      []runner.TestCase `json:"testCases,omitempty"`
+		CorrectOption  *int              `json:"correctOption,omitempty"`
+		CorrectOptions []int             `json:"correctOptions,omitempty"`
+		ModelAnswer    string            `json:"modelAnswer,omitempty"`
+		ScoringPolicy  string            `json:"scoringPolicy,omitempty"`
 	}
 	type storedSection struct {
 		SectionID          int              `json:"sectionId"`
 		Title              string           `json:"title"`
 		QuestionsToDisplay int              `json:"questionsToDisplay"`
+		ScoringPolicy      string           `json:"scoringPolicy,omitempty"`
 		Questions          []storedQuestion `json:"questions"`
 	}
 	type storedTest struct {
-		Title    string          `json:"title"`
-		Sections []storedSection `json:"sections"`
+		Title            string          `json:"title"`
+		Sections         []storedSection `json:"sections"`
+		ShuffleQuestions bool            `json:"shuffleQuestions,omitempty"`
+		ShuffleSections  bool            `json:"shuffleSections,omitempty"`
 	}
 
-	// 3) Unmarshal stored JSON
+	// 2) Unmarshal stored JSON
 	var sTest storedTest
 	if err := json.Unmarshal([]byte(test.QuestionAnswerJSON), &sTest); err != nil {
-		return 0, err
+		return "", "", err
+	}
+	// The full answer key is only used to split each section into the pools questions are picked from
+	var key TestFormat
+	if err := json.Unmarshal([]byte(test.QuestionAnswerJSON), &key); err != nil {
+		return "", "", err
 	}
 
-	// 4) Define candidate-facing output structures (like tests/q1.json)
+	// 3) Define candidate-facing output structures (like tests/q1.json)
 	type outQuestion struct {
 		FailureMarks   int      `json:"failureMarks"`
 		QuestionNumber int      `json:"questionNumber"`
@@ -223,6 +298,25 @@ func CreateQuestionAnswerJSON(test_id uint32, candidate_id uint32) (uint32, erro
 		SuccessMarks   int      `json:"successMarks"`
 		Type           string   `json:"type"`
 		Options        []string `json:"options,omitempty"`
+		Image          string   `json:"image,omitempty"`
+		OptionImages   []string `json:"optionImages,omitempty"`
+		ScoringPolicy  string   `json:"scoringPolicy,omitempty"`
+		Units          string   `json:"units,omitempty"`
+		Items          []string `json:"items,omitempty"`
+		MatchOptions   []string `json:"matchOptions,omitempty"`
+		PartialCredit  bool     `json:"partialCredit,omitempty"`
+		BlankCount     int      `json:"blankCount,omitempty"`
+		Language       string   `json:"language,omitempty"`
+		StarterCode    string   `json:"starterCode,omitempty"`
+		TimeLimitMs    int      `json:"timeLimitMs,omitempty"`
+		MemoryLimitMb  int      `json:"memoryLimitMb,omitempty"`
+		// The texts above rendered to HTML that is safe to insert into the page
+		QuestionHTML     string   `json:"questionHtml"`
+		OptionsHTML      []string `json:"optionsHtml,omitempty"`
+		ItemsHTML        []string `json:"itemsHtml,omitempty"`
+		MatchOptionsHTML []string `json:"matchOptionsHtml,omitempty"`
+		// Only the visible test cases, as examples
+		TestCases []runner.TestCase `json:"testCases,omitempty"`
 	}
 	type outSection struct {
 		SectionID int           `json:"sectionId"`
@@ -234,35 +328,112 @@ func CreateQuestionAnswerJSON(test_id uint32, candidate_id uint32) (uint32, erro
 		Title    string       `json:"title"`
 	}
 
-	// 5) Build candidate-facing question set, respecting questionsToDisplay and no repeats
+	// 4) Build candidate-facing question set, respecting questionsToDisplay and no repeats
 	var oTest outTest
+	var shuffle ShufflePattern
 	oTest.Title = sTest.Title
-	for _, sec := range sTest.Sections {
-		// Determine how many questions to pick for this section
-		nTotal := len(sec.Questions)
-		k := sec.QuestionsToDisplay
-		if k <= 0 || k > nTotal {
-			k = nTotal
+	for i, sec := range sTest.Sections {
+		// Pick from each pool, the blueprint entries or questionsToDisplay and the bank rules
+		var picked []int
+		for _, pool := range sectionPools(key.Sections[i]) {
+			nTotal := len(pool.Questions)
+			k := pool.Count
+			if k <= 0 || k > nTotal {
+				k = nTotal
+			}
+			for _, idx := range rng.sample(nTotal, k) {
+				picked = append(picked, pool.Questions[idx])
+			}
+		}
+		// Questions are shown in the order they were written unless the test shuffles them
+		order := make([]int, len(picked))
+		if sTest.ShuffleQuestions {
+			order = rng.sample(len(picked), len(picked))
+		} else {
+			sort.Ints(picked)
+			for j := range order {
+				order[j] = j
+			}
+		}
+		chosen := make([]storedQuestion, 0, len(picked))
+		for _, j := range order {
+			chosen = append(chosen, sec.Questions[picked[j]])
 		}
 
-		picked := map[int]bool{}
-		outQs := make([]outQuestion, 0, k)
-		for len(outQs) < k && nTotal > 0 {
-			idx := randInt(nTotal, picked)
-			picked[idx] = true
-
-			q := sec.Questions[idx]
+		outQs := make([]outQuestion, 0, len(chosen))
+		secShuffle := SectionShuffle{SectionID: sec.SectionID, Questions: []QuestionShuffle{}}
+		for _, q := range chosen {
 			oq := outQuestion{
 				FailureMarks:   q.FailureMarks,
 				QuestionNumber: q.QuestionNumber,
 				QuestionText:   q.QuestionText,
 				SuccessMarks:   q.SuccessMarks,
 				Type:           q.Type,
+				Image:          q.Image,
 			}
-			// Include options for MCQ/MSQ, but never include correct answers/model answers
-			if q.Type == "mcq" || q.Type == "msq" {
+			// Include options for MCQ/MSQ, but never include correct answers/model answers.
+			// Options are shuffled unless their order matters, answers are mapped back when grading.
+			if (q.Type == "mcq" || q.Type == "msq") && q.KeepOptionOrder {
 				oq.Options = append(oq.Options, q.Options...)
+				oq.OptionImages = append(oq.OptionImages, q.OptionImages...)
+			} else if q.Type == "mcq" || q.Type == "msq" {
+				qs := QuestionShuffle{QuestionNumber: q.QuestionNumber, Options: rng.shuffledPositions(len(q.Options))}
+				oq.Options = inShuffledOrder(q.Options, qs.Options)
+				if len(q.OptionImages) > 0 {
+					oq.OptionImages = inShuffledOrder(q.OptionImages, qs.Options)
+				}
+				secShuffle.Questions = append(secShuffle.Questions, qs)
+			}
+			// Units only, the accepted value and tolerance stay hidden
+			if q.Type == "numeric" {
+				oq.Units = q.Units
+			}
+			// Match and order items are shuffled, the order shown is kept to map answers back when grading
+			if q.Type == "match" || q.Type == "order" {
+				qs := QuestionShuffle{QuestionNumber: q.QuestionNumber}
+				if q.Type == "order" {
+					qs.Items = rng.shuffledOrder(len(q.Items))
+				} else {
+					qs.Items = rng.shuffledPositions(len(q.Items))
+					qs.MatchOptions = rng.shuffledPositions(len(q.MatchOptions))
+				}
+				oq.Items = inShuffledOrder(q.Items, qs.Items)
+				if q.Type == "match" {
+					oq.MatchOptions = inShuffledOrder(q.MatchOptions, qs.MatchOptions)
+				}
+				oq.PartialCredit = q.PartialCredit
+				secShuffle.Questions = append(secShuffle.Questions, qs)
+			}
+			// Only the number of blanks, never the accepted answers
+			if q.Type == "fill-blank" {
+				oq.BlankCount = len(q.Blanks)
+				oq.PartialCredit = q.PartialCredit
+			}
+			if q.Type == "code" {
+				oq.Language = q.Language
+				oq.StarterCode = q.StarterCode
+				oq.TimeLimitMs = q.TimeLimitMs
+				oq.MemoryLimitMb = q.MemoryLimitMb
+				for _, tc := range q.TestCases {
+					if !tc.Hidden {
+						oq.TestCases = append(oq.TestCases, tc)
+					}
+				}
 			}
+			// Let candidates know how partially correct msq answers are scored
+			if q.Type == "msq" {
+				oq.ScoringPolicy = q.ScoringPolicy
+				if oq.ScoringPolicy == "" {
+					oq.ScoringPolicy = sec.ScoringPolicy
+				}
+				if oq.ScoringPolicy == "" {
+					oq.ScoringPolicy = "all-or-nothing"
+				}
+			}
+			oq.QuestionHTML = content.RenderHTML(q.TextFormat, q.QuestionText)
+			oq.OptionsHTML = renderEach(q.OptionsFormat, oq.Options)
+			oq.ItemsHTML = renderEach(q.OptionsFormat, oq.Items)
+			oq.MatchOptionsHTML = renderEach(q.OptionsFormat, oq.MatchOptions)
 			outQs = append(outQs, oq)
 		}
 
@@ -271,43 +442,27 @@ func CreateQuestionAnswerJSON(test_id uint32, candidate_id uint32) (uint32, erro
 			Title:     sec.Title,
 			Questions: outQs,
 		})
+		shuffle.Sections = append(shuffle.Sections, secShuffle)
 	}
 
-	// 6) Marshal output JSON for storing in AnswerAttempt.QuestionJSON
-	qb, err := json.Marshal(oTest)
-	if err != nil {
-		return 0, err
-	}
-
-	// 7) Store in AnswerAttempt table
-	attempt := models.AnswerAttempt{
-		TestID:         test_id,
-		CandidateID:    candidate_id,
-		StartTime:      time.Time{},
-		Duration:       test.TestDuration,
-		QuestionJSON:   string(qb),
-		AnswerJSON:     "{}",
-		EvaluationJSON: "{}",
-		AchievedMarks:  0,
+	if sTest.ShuffleSections {
+		sections := make([]outSection, 0, len(oTest.Sections))
+		for _, idx := range rng.sample(len(oTest.Sections), len(oTest.Sections)) {
+			sections = append(sections, oTest.Sections[idx])
+		}
+		oTest.Sections = sections
 	}
 
-	if err := database.DB.Create(&attempt).Error; err != nil {
-		return 0, err
+	// 5) Marshal output JSON for storing in AnswerAttempt.QuestionJSON
+	qb, err := json.Marshal(oTest)
+	if err != nil {
+		return "", "", err
 	}
-
-	return uint32(attempt.AnswerID), nil
-}
-
-// randInt returns a random int in [0, n) not in picked
-func randInt(n int, picked map[int]bool) int {
-	for {
-		b := make([]byte, 1)
-		rand.Read(b)
-		idx := int(b[0]) % n
-		if !picked[idx] {
-			return idx
-		}
+	sb, err := json.Marshal(shuffle)
+	if err != nil {
+		return "", "", err
 	}
+	return string(qb), string(sb), nil
 }
 
 func InitTestForCandidate(c *gin.Context) {
@@ -345,6 +500,16 @@ func InitTestForCandidate(c *gin.Context) {
 		return
 	}
 
+	// Attempts can only be initialised while the test is open
+	var test models.Test
+	if err := database.DB.Where("test_id = ?", req.TestID).First(&test).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Test not found"})
+		return
+	}
+	if !ensureTestOpen(c, test) {
+		return
+	}
+
 	// Create question set for this candidate and store in AnswerAttempt table
 	answerID, err := CreateQuestionAnswerJSON(req.TestID, assignedTest.CandidateID)
 	if err != nil {
@@ -397,18 +562,38 @@ func StartTestAttempt(c *gin.Context) {
 		c.JSON(http.StatusForbidden, gin.H{"error": "Test has already been started"})
 		return
 	}
+	if attempt.Status == "abandoned" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Test attempt was not started before the test closed"})
+		return
+	}
+
+	// Fetch the test to check its window and duration (in minutes)
+	var test models.Test
+	if err := database.DB.Where("test_id = ?", req.TestID).First(&test).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve test"})
+		return
+	}
+	if !ensureTestOpen(c, test) {
+		return
+	}
+
 	// Update the start time to current time
-	// Start the test
+	// Start the test, an attempt never runs past the test's end time
 	attempt.StartTime = time.Now()
+	attempt.EndTime = attempt.StartTime.Add(time.Duration(attempt.Duration) * time.Minute)
+	if attempt.EndTime.After(test.TestEndTime) {
+		attempt.EndTime = test.TestEndTime
+	}
+	attempt.Status = "in-progress"
 	if err := database.DB.Save(&attempt).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start test"})
 		return
 	}
 
-	// Fetch test duration (in minutes) from the Test table
-	var test models.Test
-	if err := database.DB.Select("test_duration").Where("test_id = ?", req.TestID).First(&test).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve test duration"})
+	// Links to exactly the images on this candidate's paper, valid until the attempt closes
+	imageURLs, err := paperImageURLs(attempt.QuestionJSON, time.Until(attempt.EndTime)+5*time.Minute)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate image URLs: " + err.Error()})
 		return
 	}
 
@@ -416,10 +601,44 @@ func StartTestAttempt(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
 		"message":          "Test started successfully",
 		"question_json":    attempt.QuestionJSON,
+		"image_urls":       imageURLs,
 		"duration_minutes": test.TestDuration,
+		"end_time":         attempt.EndTime,
 	})
 }
 
+// paperImageURLs presigns every image referenced by a candidate's question set, keyed by file name
+func paperImageURLs(questionJSON string, expiry time.Duration) (map[string]string, error) {
+	var paper struct {
+		Sections []struct {
+			Questions []struct {
+				Image        string   `json:"image"`
+				OptionImages []string `json:"optionImages"`
+			} `json:"questions"`
+		} `json:"sections"`
+	}
+	if err := json.Unmarshal([]byte(questionJSON), &paper); err != nil {
+		return nil, err
+	}
+
+	urls := map[string]string{}
+	for _, sec := range paper.Sections {
+		for _, q := range sec.Questions {
+			for _, name := range append([]string{q.Image}, q.OptionImages...) {
+				if name == "" || urls[name] != "" {
+					continue
+				}
+				url, err := database.GetPresignedURL(name, expiry)
+				if err != nil {
+					return nil, err
+				}
+				urls[name] = url
+			}
+		}
+	}
+	return urls, nil
+}
+
 func UpdateTestAttempt(c *gin.Context) {
 	var req UpdateTestAttemptRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -447,8 +666,12 @@ func UpdateTestAttempt(c *gin.Context) {
 				c.JSON(http.StatusBadRequest, gin.H{"error": "Each answer must have a valid questionNumber (>0)"})
 				return
 			}
-			if ans.CorrectOption == nil && len(ans.CorrectOptions) == 0 && (ans.Answer == nil || *ans.Answer == "") {
-				c.JSON(http.StatusBadRequest, gin.H{"error": "Each answer must have one of: CorrectOption, CorrectOptions, or answer"})
+			if ans.CorrectOption == nil && len(ans.CorrectOptions) == 0 && (ans.Answer == nil || *ans.Answer == "") && ans.NumericAnswer == nil && len(ans.Matches) == 0 && len(ans.Order) == 0 && len(ans.Blanks) == 0 && (ans.Code == nil || *ans.Code == "") {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Each answer must have one of: CorrectOption, CorrectOptions, answer, numericAnswer, matches, order, blanks, or code"})
+				return
+			}
+			if ans.Code != nil && len(*ans.Code) > runner.MaxSourceBytes {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Code answers must be at most %d bytes", runner.MaxSourceBytes)})
 				return
 			}
 		}
@@ -481,20 +704,33 @@ func UpdateTestAttempt(c *gin.Context) {
 		return
 	}
 
-	// Check if the attempt exists and belongs to the test
+	// Check if the attempt exists and belongs to the candidate
+	var candidate models.User
+	if err := database.DB.Where("email = ?", req.Email).First(&candidate).Error; err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
 	var attempt models.AnswerAttempt
-	if err := database.DB.Where("answer_id = ?", req.AttemptId).First(&attempt).Error; err != nil {
+	if err := database.DB.Where("answer_id = ? AND candidate_id = ?", req.AttemptId, candidate.ID).First(&attempt).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Attempt not found"})
 		return
 	}
 
-	// Ensure the attempt has been started and is within the allowed duration (+5 min grace)
+	// Ensure the attempt has been started, is not yet submitted and is within the allowed duration (+5 min grace)
 	if attempt.StartTime.IsZero() {
 		c.JSON(http.StatusForbidden, gin.H{"error": "Test attempt has not been started"})
 		return
 	}
-	allowedEnd := attempt.StartTime.Add(time.Duration(attempt.Duration) * time.Minute).Add(5 * time.Minute)
-	if time.Now().After(allowedEnd) {
+	if attempt.Status != "in-progress" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Test attempt has already been submitted"})
+		return
+	}
+	if time.Now().After(attemptDeadline(attempt).Add(5 * time.Minute)) {
+		// Auto-submit the attempt with the answers saved so far, unless the worker got there first
+		if err := finaliseAttempt(&attempt, true); err != nil && err != errAttemptAlreadyClosed {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to close test attempt: " + err.Error()})
+			return
+		}
 		c.JSON(http.StatusForbidden, gin.H{"error": "Time window for this attempt has expired"})
 		return
 	}
@@ -505,13 +741,126 @@ func UpdateTestAttempt(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to marshal answer"})
 		return
 	}
-	attempt.AnswerJSON = string(answerJSONBytes)
-	attempt.AchievedMarks = 0
 
-	if err := database.DB.Save(&attempt).Error; err != nil {
+	// Only the answers are written, and only while the attempt is still open, so a save racing
+	// a submit, the auto-submit worker or the test closing cannot reopen or overwrite it
+	result := database.DB.Model(&models.AnswerAttempt{}).
+		Where("answer_id = ? AND status = ?", attempt.AnswerID, "in-progress").
+		Update("answer_json", string(answerJSONBytes))
+	if result.Error != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update test attempt"})
 		return
 	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Test attempt has already been submitted"})
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Test attempt updated successfully"})
 }
+
+func SubmitTestAttempt(c *gin.Context) {
+	var req SubmitTestAttemptRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	// Check if token is validon redis for the email, if yes then proceed
+	storedToken, err := database.RedisClient.Get(context.Background(), "email:"+req.Email).Result()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve token from Redis"})
+		return
+	}
+
+	if storedToken != req.Token {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+		return
+	}
+
+	// Bump the expiry by next 15 minutes
+	if err := database.RedisClient.Expire(context.Background(), "email:"+req.Email, 15*time.Minute).Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to extend token expiry"})
+		return
+	}
+
+	// Check if the attempt exists and belongs to the candidate
+	var candidate models.User
+	if err := database.DB.Where("email = ?", req.Email).First(&candidate).Error; err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+	var attempt models.AnswerAttempt
+	if err := database.DB.Where("answer_id = ? AND candidate_id = ?", req.AttemptId, candidate.ID).First(&attempt).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Attempt not found"})
+		return
+	}
+
+	if attempt.StartTime.IsZero() {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Test attempt has not been started"})
+		return
+	}
+	if attempt.Status != "in-progress" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Test attempt has already been submitted"})
+		return
+	}
+
+	// Submissions after the grace window are recorded as automatic, with the answers saved in time
+	expired := time.Now().After(attemptDeadline(attempt).Add(5 * time.Minute))
+	if err := finaliseAttempt(&attempt, expired); err != nil {
+		if err == errAttemptAlreadyClosed {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Test attempt has already been submitted"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to submit test attempt: " + err.Error()})
+		return
+	}
+	if expired {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Time window for this attempt has expired"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":     "Test attempt submitted successfully",
+		"submit_time": attempt.SubmitTime,
+	})
+}
+
+// attemptDeadline returns the time at which the attempt's allotted duration runs out
+func attemptDeadline(attempt models.AnswerAttempt) time.Time {
+	// Attempts started before end times were recorded only have their duration
+	if attempt.EndTime.IsZero() {
+		return attempt.StartTime.Add(time.Duration(attempt.Duration) * time.Minute)
+	}
+	return attempt.EndTime
+}
+
+// testWindowStatus reports where a test is for candidates: inactive, not-yet-open, open or closed
+func testWindowStatus(test models.Test, now time.Time) string {
+	switch {
+	case !test.TestActive:
+		return "inactive"
+	case now.Before(test.TestStartTime):
+		return "not-yet-open"
+	case !now.Before(test.TestEndTime):
+		return "closed"
+	default:
+		return "open"
+	}
+}
+
+// ensureTestOpen writes an error response with a machine readable code and returns false
+// unless the test is active and within its start and end time
+func ensureTestOpen(c *gin.Context, test models.Test) bool {
+	switch testWindowStatus(test, time.Now()) {
+	case "inactive":
+		c.JSON(http.StatusForbidden, gin.H{"error": "Test is not active", "code": "TEST_INACTIVE"})
+		return false
+	case "not-yet-open":
+		c.JSON(http.StatusForbidden, gin.H{"error": "Test has not opened yet", "code": "TEST_NOT_YET_OPEN", "test_start_time": test.TestStartTime})
+		return false
+	case "closed":
+		c.JSON(http.StatusForbidden, gin.H{"error": "Test has closed", "code": "TEST_CLOSED", "test_end_time": test.TestEndTime})
+		return false
+	}
+	return true
+}
@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"image"
 	"image/jpeg"
@@ -394,6 +395,15 @@ func DeleteImage(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Image not found in test"})
 		return
 	}
+
+	// Refuse to delete an image a question still shows
+	var tf TestFormat
+	if test.QuestionAnswerJSON != "" && json.Unmarshal([]byte(test.QuestionAnswerJSON), &tf) == nil {
+		if usedBy := imageReferencedBy(tf, req.Filename); usedBy != "" {
+			c.JSON(http.StatusConflict, gin.H{"error": "Image is used by " + usedBy + ", remove it from the question first"})
+			return
+		}
+	}
 	test.Images = append(test.Images[:imageIndex], test.Images[imageIndex+1:]...)
 
 	// delete the image from object storage
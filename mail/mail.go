@@ -9,11 +9,13 @@ import (
 )
 
 var (
-	newUserTemplate  string
-	oldUserTemplate  string
-	invoiceTemplate  string
-	newLoginTemplate string
-	auth             smtp.Auth
+	newUserTemplate     string
+	oldUserTemplate     string
+	invoiceTemplate     string
+	newLoginTemplate    string
+	resultsTemplate     string
+	certificateTemplate string
+	auth                smtp.Auth
 )
 
 func LoadEmailTemplates() {
@@ -438,6 +440,244 @@ func LoadEmailTemplates() {
     </div>
   </div>
 </body>
+</html>`
+
+	// Load Test Results Email Template
+	resultsTemplate = `<html>
+<head>
+  <meta charset="UTF-8" />
+  <meta name="viewport" content="width=device-width, initial-scale=1.0" />
+  <style>
+    body {
+      font-family: Arial, sans-serif;
+      background-color: #f4f4f7;
+      margin: 0;
+      padding: 0;
+    }
+    .container {
+      max-width: 600px;
+      background: #ffffff;
+      margin: 40px auto;
+      padding: 30px;
+      border-radius: 8px;
+      box-shadow: 0 2px 8px rgba(0,0,0,0.05);
+    }
+    h2 {
+      color: #d9534f;
+    }
+    p {
+      font-size: 16px;
+      color: #555;
+      line-height: 1.6;
+    }
+    .details-box {
+      margin-top: 20px;
+      border: 1px solid #eee;
+      border-radius: 6px;
+      padding: 20px;
+      background-color: #fafafa;
+    }
+    .details-box table {
+      width: 100%%;
+      border-collapse: collapse;
+    }
+    .details-box table td {
+      padding: 10px;
+      font-size: 15px;
+      color: #444;
+    }
+    .button-container {
+      text-align: center;
+      margin: 30px 0;
+    }
+    .button {
+      background-color: #007BFF;
+      color: white !important;
+      padding: 14px 30px;
+      text-decoration: none;
+      border-radius: 6px;
+      font-size: 16px;
+      display: inline-block;
+      font-weight: bold;
+    }
+    .footer {
+      font-size: 12px;
+      color: #999;
+      text-align: center;
+      margin-top: 40px;
+    }
+    .footer a {
+      color: #007BFF;
+      text-decoration: none;
+    }
+    @media (max-width: 600px) {
+      .container {
+        padding: 20px;
+        margin: 20px;
+      }
+      .button {
+        width: 100%%;
+        box-sizing: border-box;
+      }
+    }
+  </style>
+</head>
+<body>
+  <div class="container">
+    <h2>📊 Your results are out</h2>
+    <p>Hello %s,</p>
+    <p>The results for <strong>%s</strong> on <strong>Quantum Scholar</strong> have been released. Here is your score:</p>
+
+    <div class="details-box">
+      <table>
+        <tr>
+          <td><strong>Test</strong></td>
+          <td>%s</td>
+        </tr>
+        <tr>
+          <td><strong>Score</strong></td>
+          <td>%s / %s</td>
+        </tr>
+        <tr>
+          <td><strong>Submitted</strong></td>
+          <td>%s</td>
+        </tr>
+      </table>
+    </div>
+
+    <p>Log in to the test portal to see your section-wise breakdown.</p>
+
+    <div class="button-container">
+      <a href="%s/test-portal" class="button">View Results</a>
+    </div>
+
+    <div class="footer">
+      <p>You are receiving this email from <strong>Quantum Scholar</strong> because you attempted a test assigned to you.<br />
+        If you need assistance, please <a href="%s/support">contact support</a>.
+      </p>
+      <p>Qubitopia Inc. | India | <a href="%s/privacypolicy">Privacy Policy</a></p>
+    </div>
+  </div>
+</body>
+</html>`
+
+	// Load Certificate Email Template
+	certificateTemplate = `<html>
+<head>
+  <meta charset="UTF-8" />
+  <meta name="viewport" content="width=device-width, initial-scale=1.0" />
+  <style>
+    body {
+      font-family: Arial, sans-serif;
+      background-color: #f4f4f7;
+      margin: 0;
+      padding: 0;
+    }
+    .container {
+      max-width: 600px;
+      background: #ffffff;
+      margin: 40px auto;
+      padding: 30px;
+      border-radius: 8px;
+      box-shadow: 0 2px 8px rgba(0,0,0,0.05);
+    }
+    h2 {
+      color: #28a745;
+    }
+    p {
+      font-size: 16px;
+      color: #555;
+      line-height: 1.6;
+    }
+    .details-box {
+      margin-top: 20px;
+      border: 1px solid #eee;
+      border-radius: 6px;
+      padding: 20px;
+      background-color: #fafafa;
+    }
+    .details-box table {
+      width: 100%%;
+      border-collapse: collapse;
+    }
+    .details-box table td {
+      padding: 10px;
+      font-size: 15px;
+      color: #444;
+    }
+    .button-container {
+      text-align: center;
+      margin: 30px 0;
+    }
+    .button {
+      background-color: #007BFF;
+      color: white !important;
+      padding: 14px 30px;
+      text-decoration: none;
+      border-radius: 6px;
+      font-size: 16px;
+      display: inline-block;
+      font-weight: bold;
+    }
+    .footer {
+      font-size: 12px;
+      color: #999;
+      text-align: center;
+      margin-top: 40px;
+    }
+    .footer a {
+      color: #007BFF;
+      text-decoration: none;
+    }
+    @media (max-width: 600px) {
+      .container {
+        padding: 20px;
+        margin: 20px;
+      }
+      .button {
+        width: 100%%;
+        box-sizing: border-box;
+      }
+    }
+  </style>
+</head>
+<body>
+  <div class="container">
+    <h2>🎓 Your certificate is ready</h2>
+    <p>Hello %s,</p>
+    <p>Congratulations on passing <strong>%s</strong> on <strong>Quantum Scholar</strong>. Your certificate has been issued:</p>
+
+    <div class="details-box">
+      <table>
+        <tr>
+          <td><strong>Test</strong></td>
+          <td>%s</td>
+        </tr>
+        <tr>
+          <td><strong>Score</strong></td>
+          <td>%s / %s</td>
+        </tr>
+        <tr>
+          <td><strong>Certificate ID</strong></td>
+          <td>%s</td>
+        </tr>
+      </table>
+    </div>
+
+    <p>Anyone you share the certificate with can check it is genuine by scanning the QR code printed on it.</p>
+
+    <div class="button-container">
+      <a href="%s" class="button">Download Certificate</a>
+    </div>
+
+    <div class="footer">
+      <p>You are receiving this email from <strong>Quantum Scholar</strong> because you passed a test assigned to you.<br />
+        If you need assistance, please <a href="%s/support">contact support</a>.
+      </p>
+      <p>Qubitopia Inc. | India | <a href="%s/privacypolicy">Privacy Policy</a></p>
+    </div>
+  </div>
+</body>
 </html>`
 }
 
@@ -517,3 +757,33 @@ func SendEmailNotificationOfUserLogin(to string, Name string, timestamp string,
 	log.Println("✅ Email sent successfully.")
 	return nil
 }
+
+func SendEmailTestResults(to string, Name string, testName string, marks string, maxMarks string, submittedAt string) error {
+	// Email content
+	subject := fmt.Sprintf("Subject: Results released for %s\r\n", testName)
+	body := fmt.Sprintf(resultsTemplate, Name, testName, testName, marks, maxMarks, submittedAt, database.BASE_URL, database.BASE_URL, database.BASE_URL)
+
+	// Send email
+	err := sendEmail(to, subject, body)
+	if err != nil {
+		log.Println("Failed to send email:", err)
+		return err
+	}
+	log.Println("✅ Email sent successfully.")
+	return nil
+}
+
+func SendEmailCertificate(to string, Name string, testName string, marks string, maxMarks string, certificateID string, downloadLink string) error {
+	// Email content
+	subject := fmt.Sprintf("Subject: Your certificate for %s\r\n", testName)
+	body := fmt.Sprintf(certificateTemplate, Name, testName, testName, marks, maxMarks, certificateID, downloadLink, database.BASE_URL, database.BASE_URL)
+
+	// Send email
+	err := sendEmail(to, subject, body)
+	if err != nil {
+		log.Println("Failed to send email:", err)
+		return err
+	}
+	log.Println("✅ Email sent successfully.")
+	return nil
+}
@@ -3,16 +3,22 @@ package main
 import (
 	"log"
 
+	"github.com/Qubitopia/quantum-scholar-backend/certificate"
 	"github.com/Qubitopia/quantum-scholar-backend/database"
+	"github.com/Qubitopia/quantum-scholar-backend/grader"
 	"github.com/Qubitopia/quantum-scholar-backend/handlers"
 	"github.com/Qubitopia/quantum-scholar-backend/mail"
 	"github.com/Qubitopia/quantum-scholar-backend/middleware"
 	"github.com/Qubitopia/quantum-scholar-backend/payment"
+	"github.com/Qubitopia/quantum-scholar-backend/runner"
 
 	"github.com/gin-gonic/gin"
 )
 
 func main() {
+	// The code runner starts this binary again to set up each sandbox, that copy never returns
+	runner.RunSandboxInit()
+
 	// Load environment variables into global variables
 	database.LoadEnvVariables()
 
@@ -33,9 +39,24 @@ func main() {
 	mail.LoadEmailTemplates()
 	mail.InitEmail()
 
+	// Initialize grader for open-ended answers
+	grader.InitGrader()
+
+	// Initialize the sandbox that runs answers to code questions
+	runner.InitRunner()
+
+	// Initialize the key that signs certificates
+	certificate.InitSigner()
+
 	// test
 	handlers.CreateQuestionAnswerJSON(1, 1)
 
+	// Auto-submit attempts whose time has run out
+	go handlers.StartAutoSubmitWorker()
+
+	// Grade submitted attempts, retrying those that failed
+	handlers.StartGradingWorker()
+
 	// Initialize Gin router
 	r := gin.Default()
 	r.TrustedPlatform = gin.PlatformCloudflare
@@ -54,6 +75,11 @@ func main() {
 		c.JSON(200, gin.H{"status": "ok"})
 	})
 
+	// Certificate download and verification (public)
+	r.GET("/certificate/:id/download", handlers.DownloadCertificate)
+	r.GET("/verify/certificate/:id", handlers.VerifyCertificate)
+	r.GET("/verify/public-key", handlers.GetCertificatePublicKey)
+
 	// Auth routes (public)
 	auth := r.Group("/auth")
 	{
@@ -89,6 +115,42 @@ func main() {
 		api.PUT("/test/add-candidates", handlers.AddCandidatesToTest)
 		api.GET("/test/:id/candidates", handlers.GetAllCandidatesAssignedToTest)
 		api.PUT("/test/remove-candidates", handlers.RemoveCandidatesFromTest)
+		api.PUT("/test/:id/publish", handlers.PublishTest)
+		api.PUT("/test/:id/close", handlers.CloseTest)
+		api.PUT("/test/:id/archive", handlers.ArchiveTest)
+
+		// Question bank
+		api.POST("/question-bank", handlers.CreateBankQuestion)
+		api.GET("/question-bank", handlers.SearchBankQuestions)
+		api.GET("/question-bank/:id", handlers.GetBankQuestionByID)
+		api.PUT("/question-bank/:id", handlers.UpdateBankQuestion)
+		api.DELETE("/question-bank/:id", handlers.DeleteBankQuestion)
+
+		// Generated papers, regenerated from the attempt's seed for audits
+		api.GET("/test/:id/attempts/:attempt_id/paper", handlers.GetAttemptPaper)
+
+		// Manual grading
+		api.GET("/test/:id/grading", handlers.GetPendingResponses)
+		api.POST("/test/:id/grading", handlers.SubmitManualGrade)
+
+		// Answer key corrections and re-grading
+		api.PUT("/test/:id/answer-key", handlers.CorrectAnswerKey)
+		api.POST("/test/:id/regrade", handlers.RegradeTest)
+		api.GET("/test/:id/regrades", handlers.GetRegrades)
+
+		// Analytics
+		api.GET("/test/:id/analytics", handlers.GetTestAnalytics)
+		api.GET("/test/:id/export", handlers.ExportTestResults)
+
+		// Results
+		api.PUT("/test/:id/results-settings", handlers.UpdateResultsSettings)
+		api.PUT("/test/:id/release-results", handlers.ReleaseResults)
+
+		// Certificates
+		api.PUT("/test/:id/certificate-settings", handlers.UpdateCertificateSettings)
+		api.POST("/test/:id/certificates", handlers.IssueCertificates)
+		api.GET("/test/:id/certificates", handlers.GetCertificatesForTest)
+		api.PUT("/certificate/:id/revoke", handlers.RevokeCertificate)
 
 		// Image upload
 		api.POST("/bulk-image-upload/:test_id", handlers.BulkImageUpload)
@@ -108,6 +170,8 @@ func main() {
 		test_portal.POST("/init", handlers.InitTestForCandidate)
 		test_portal.POST("/start", handlers.StartTestAttempt)
 		test_portal.POST("/update-attempt", handlers.UpdateTestAttempt)
+		test_portal.POST("/submit", handlers.SubmitTestAttempt)
+		test_portal.POST("/result", handlers.GetCandidateResult)
 	}
 
 	// Start server
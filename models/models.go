@@ -46,6 +46,14 @@ type Test struct {
 	TestStartTime              time.Time      `json:"test_start_time" gorm:"not null"`
 	TestEndTime                time.Time      `json:"test_end_time" gorm:"not null"`
 	TestActive                 bool           `json:"test_active" gorm:"default:false"`
+	Status                     string         `json:"status" gorm:"default:'draft'"`           // draft, published, closed, archived
+	ResultsRelease             string         `json:"results_release" gorm:"default:'manual'"` // immediate, scheduled or manual
+	ResultsReleaseTime         time.Time      `json:"results_release_time"`                    // when scheduled results are released
+	ResultsReleasedAt          time.Time      `json:"results_released_at"`                     // set once scheduled or manual results are out
+	ShowCorrectAnswers         bool           `json:"show_correct_answers" gorm:"default:false"`
+	EmailResults               bool           `json:"email_results" gorm:"default:false"`
+	CertificatesEnabled        bool           `json:"certificates_enabled" gorm:"default:false"`
+	PassPercentage             float64        `json:"pass_percentage" gorm:"default:0"` // minimum score for a certificate
 	CreatedAt                  time.Time      `json:"created_at"`
 	// Foreign keys
 	// Examiner User `gorm:"foreignKey:ExaminerID"`
@@ -65,19 +73,42 @@ type TestAssignedToUser struct {
 
 // Answer model
 type AnswerAttempt struct {
-	AnswerID       uint64    `json:"answer_id" gorm:"primaryKey"`
-	TestID         uint32    `json:"test_id" gorm:"not null"`
-	CandidateID    uint32    `json:"candidate_id" gorm:"not null"`
-	StartTime      time.Time `json:"start_time"`
-	Duration       uint8     `json:"duration"`
-	QuestionJSON   string    `json:"question_json" gorm:"type:jsonb"`
-	AnswerJSON     string    `json:"answer_json" gorm:"type:jsonb"`
-	EvaluationJSON string    `json:"evaluation_json" gorm:"type:jsonb"`
-	AchievedMarks  uint8     `json:"achieved_marks"`
+	AnswerID         uint64    `json:"answer_id" gorm:"primaryKey"`
+	TestID           uint32    `json:"test_id" gorm:"not null"`
+	CandidateID      uint32    `json:"candidate_id" gorm:"not null"`
+	StartTime        time.Time `json:"start_time"`
+	Duration         uint8     `json:"duration"`
+	EndTime          time.Time `json:"end_time"`                            // StartTime + Duration, capped at the test's end time
+	Status           string    `json:"status" gorm:"default:'initialized'"` // initialized, in-progress, submitted, abandoned
+	SubmitTime       time.Time `json:"submit_time"`
+	AutoSubmitted    bool      `json:"auto_submitted" gorm:"default:false"` // submitted by the server when time ran out
+	GradingStatus    string    `json:"grading_status"`                      // pending, grading, graded or failed once submitted
+	GradingClaimed   time.Time `json:"-"`                                   // when a grading worker took the attempt, see handlers.gradeClaimedAttempt
+	GradingTries     int       `json:"grading_tries" gorm:"default:0"`      // times a grading worker has claimed the attempt
+	GradingHeartbeat time.Time `json:"-"`                                   // last time a grading worker worked on the attempt, a claim without a recent one is stale
+	QuestionJSON     string    `json:"question_json" gorm:"type:jsonb"`
+	AnswerJSON       string    `json:"answer_json" gorm:"type:jsonb"`
+	EvaluationJSON   string    `json:"evaluation_json" gorm:"type:jsonb"`
+	ShuffleJSON      string    `json:"-" gorm:"type:jsonb;default:'{}'"` // order the items of each question were shown in, never sent to the candidate
+	PaperSeed        string    `json:"-"`                                // hex seed every random choice of the paper was made with, see handlers.generatePaper
+	AchievedMarks    float64   `json:"achieved_marks"`
 	// Foreign keys
 	// Candidate User `gorm:"foreignKey:CandidateID"`
 }
 
+// BankQuestion model, a question in an examiner's question bank that tests can reuse
+type BankQuestion struct {
+	BankQuestionID uint32         `json:"bank_question_id" gorm:"primaryKey"`
+	OwnerID        uint32         `json:"owner_id" gorm:"not null;index"`
+	Type           string         `json:"type" gorm:"not null"`
+	Topic          string         `json:"topic"`
+	Difficulty     string         `json:"difficulty"` // easy, medium or hard, empty when not rated
+	Tags           pq.StringArray `json:"tags" gorm:"type:text[]"`
+	QuestionJSON   string         `json:"question_json" gorm:"type:jsonb"` // the question in the test format, without a question number
+	CreatedAt      time.Time      `json:"created_at"`
+	UpdatedAt      time.Time      `json:"updated_at"`
+}
+
 // PaymentTable model
 type PaymentTable struct {
 	OrderID           uint32    `json:"order_id" gorm:"primaryKey"`
@@ -94,4 +125,44 @@ type PaymentTable struct {
 	// User User `gorm:"foreignKey:UserID"`
 }
 
-// Certificate Table
+// Regrade model, one row per re-grade run over a test's submitted attempts
+type Regrade struct {
+	RegradeID        uint32    `json:"regrade_id" gorm:"primaryKey"`
+	TestID           uint32    `json:"test_id" gorm:"not null;index"`
+	ExaminerID       uint32    `json:"examiner_id" gorm:"not null"`
+	SectionID        int       `json:"section_id"`      // 0 when the whole test was re-graded
+	QuestionNumber   int       `json:"question_number"` // 0 when the whole test was re-graded
+	Reason           string    `json:"reason"`
+	Status           string    `json:"status" gorm:"default:'completed'"` // running until every attempt has been re-graded, then completed
+	AttemptsTotal    int       `json:"attempts_total"`
+	AttemptsRegraded int       `json:"attempts_regraded"`
+	AttemptsFailed   int       `json:"attempts_failed"`
+	ScoresChanged    int       `json:"scores_changed"`
+	ChangesJSON      string    `json:"changes_json" gorm:"type:jsonb"` // before and after marks of every attempt whose score changed
+	CreatedAt        time.Time `json:"created_at"`
+	CompletedAt      time.Time `json:"completed_at"`
+}
+
+// Certificate Table, one signed credential per graded attempt
+type Certificate struct {
+	CertificateID string    `json:"certificate_id" gorm:"primaryKey"` // random, printed on the certificate
+	TestID        uint32    `json:"test_id" gorm:"not null;index"`
+	AttemptID     uint64    `json:"attempt_id" gorm:"not null;uniqueIndex:idx_certificates_active_attempt,where:revoked = false"` // at most one unrevoked credential per attempt
+	CandidateID   uint32    `json:"candidate_id" gorm:"not null;index"`
+	HolderName    string    `json:"holder_name" gorm:"not null"`
+	TestName      string    `json:"test_name" gorm:"not null"`
+	Score         float64   `json:"score"`
+	MaxScore      float64   `json:"max_score"`
+	Passed        bool      `json:"passed"`
+	ObjectKey     string    `json:"object_key"` // PDF in object storage, only for passing attempts of tests with certificates enabled
+	IssuedAt      time.Time `json:"issued_at"`
+	// Signed credential, see certificate.Credential
+	Payload   string `json:"payload" gorm:"not null"`
+	Signature string `json:"signature" gorm:"not null"` // Ed25519 over Payload, base64url
+	// Revocation by the examiner who owns the test
+	Revoked          bool       `json:"revoked" gorm:"default:false"`
+	RevokedAt        *time.Time `json:"revoked_at"`
+	RevokedBy        uint32     `json:"revoked_by,omitempty"`
+	RevocationStatus string     `json:"revocation_status,omitempty"` // revoked by the examiner, or superseded by a reissued credential; all the public sees
+	RevocationReason string     `json:"revocation_reason,omitempty"` // internal, never shown on the public verify page
+}
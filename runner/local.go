@@ -0,0 +1,244 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// language says how a submission is written to disk, built and started
+type language struct {
+	source  string   // file the submission is saved as
+	compile []string // nil for interpreted languages
+	run     []string
+}
+
+var localLanguages = map[string]language{
+	"python": {source: "main.py", run: []string{"python3", "-I", "main.py"}},
+	"c":      {source: "main.c", compile: []string{"gcc", "-O2", "-std=c11", "-o", "main", "main.c", "-lm"}, run: []string{"./main"}},
+	"cpp":    {source: "main.cpp", compile: []string{"g++", "-O2", "-std=c++17", "-o", "main", "main.cpp"}, run: []string{"./main"}},
+}
+
+// Compilers get more room than the submissions they build
+const (
+	compileTimeout     = 20 * time.Second
+	compileMemoryBytes = 1024 << 20
+	maxStdoutBytes     = 64 << 10
+	maxStderrBytes     = 8 << 10
+	maxFileBytes       = 16 << 20
+	maxProcesses       = 32
+)
+
+// LocalRunner runs submissions as processes on this server, each in a fresh directory with
+// resource limits, inside a Linux sandbox that only shows it that directory and the system's
+// compilers and interpreters
+type LocalRunner struct {
+	languages map[string]language
+	slots     chan int // caps how many submissions run at once, the slot number picks the sandbox uid
+}
+
+// NewLocalRunner enables the languages whose compiler or interpreter is installed, none when
+// this host cannot build the sandbox
+func NewLocalRunner() *LocalRunner {
+	r := &LocalRunner{
+		languages: map[string]language{},
+		slots:     make(chan int, runtime.NumCPU()),
+	}
+	for slot := 0; slot < cap(r.slots); slot++ {
+		r.slots <- slot
+	}
+	for name, lang := range localLanguages {
+		tool := lang.run[0]
+		if lang.compile != nil {
+			tool = lang.compile[0]
+		}
+		if _, err := exec.LookPath(tool); err == nil {
+			r.languages[name] = lang
+		}
+	}
+	if len(r.languages) > 0 {
+		if err := checkSandbox(); err != nil {
+			log.Println("Code answers cannot be sandboxed on this host:", err)
+			r.languages = map[string]language{}
+		}
+	}
+	return r
+}
+
+// Languages lists the enabled languages
+func (r *LocalRunner) Languages() []string {
+	names := make([]string, 0, len(r.languages))
+	for name := range r.languages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (r *LocalRunner) Supports(language string) bool {
+	_, ok := r.languages[language]
+	return ok
+}
+
+func (r *LocalRunner) Run(ctx context.Context, req Request) (Result, error) {
+	var result Result
+	lang, ok := r.languages[req.Language]
+	if !ok {
+		return result, fmt.Errorf("language %q is not supported", req.Language)
+	}
+	if len(req.Source) > MaxSourceBytes {
+		return result, fmt.Errorf("source is larger than %d bytes", MaxSourceBytes)
+	}
+
+	var slot int
+	select {
+	case slot = <-r.slots:
+		defer func() { r.slots <- slot }()
+	case <-ctx.Done():
+		return result, ctx.Err()
+	}
+
+	// The submission only sees work, root is where the sandbox builds its file system
+	dir, err := os.MkdirTemp("", "qs-run-")
+	if err != nil {
+		return result, err
+	}
+	defer os.RemoveAll(dir)
+	if err := os.Mkdir(filepath.Join(dir, "work"), 0o755); err != nil {
+		return result, err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "work", lang.source), []byte(req.Source), 0o644); err != nil {
+		return result, err
+	}
+	if err := prepareSandboxDir(dir, slot); err != nil {
+		return result, err
+	}
+
+	if lang.compile != nil {
+		out, err := execute(ctx, dir, slot, lang.compile, "", Limits{Timeout: compileTimeout, MemoryBytes: compileMemoryBytes})
+		if err != nil {
+			return result, err
+		}
+		if out.timedOut || out.exitCode != 0 {
+			result.CompileError = out.stderr
+			if out.timedOut {
+				result.CompileError = "compilation timed out"
+			}
+			for _, tc := range req.TestCases {
+				result.TestCases = append(result.TestCases, TestCaseResult{Status: "compile-error", Hidden: tc.Hidden})
+			}
+			return result, nil
+		}
+	}
+
+	for _, tc := range req.TestCases {
+		out, err := execute(ctx, dir, slot, lang.run, tc.Input, req.Limits)
+		if err != nil {
+			return result, err
+		}
+		tcResult := TestCaseResult{
+			Hidden:     tc.Hidden,
+			Stdout:     out.stdout,
+			Stderr:     out.stderr,
+			DurationMs: out.duration.Milliseconds(),
+		}
+		switch {
+		case out.timedOut:
+			tcResult.Status = "time-limit-exceeded"
+		case out.outOfMemory:
+			tcResult.Status = "memory-limit-exceeded"
+		case out.exitCode != 0:
+			tcResult.Status = "runtime-error"
+		case OutputMatches(tc.ExpectedOutput, out.stdout):
+			tcResult.Status = "passed"
+			result.Passed++
+		default:
+			tcResult.Status = "wrong-answer"
+		}
+		result.TestCases = append(result.TestCases, tcResult)
+	}
+	return result, nil
+}
+
+type execution struct {
+	stdout      string
+	stderr      string
+	exitCode    int
+	timedOut    bool
+	outOfMemory bool
+	duration    time.Duration
+}
+
+// execute runs one command in the run's sandbox, which applies the limits before it execs
+// the command in /work
+func execute(ctx context.Context, dir string, slot int, argv []string, stdin string, limits Limits) (execution, error) {
+	var out execution
+	ctx, cancel := context.WithTimeout(ctx, limits.Timeout)
+	defer cancel()
+
+	cmd, setupErr, err := sandboxCommand(ctx, dir, slot, argv, limits)
+	if err != nil {
+		return out, err
+	}
+	cmd.Env = []string{"PATH=/usr/local/bin:/usr/bin:/bin", "HOME=/work", "TMPDIR=/tmp", "LANG=C.UTF-8"}
+	cmd.Stdin = strings.NewReader(stdin)
+	stdout := &cappedBuffer{limit: maxStdoutBytes}
+	stderr := &cappedBuffer{limit: maxStderrBytes}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	start := time.Now()
+	err = cmd.Run()
+	out.duration = time.Since(start)
+	out.stdout = stdout.String()
+	out.stderr = stderr.String()
+	if setupErr := setupErr(); setupErr != nil {
+		return out, setupErr
+	}
+
+	if ctx.Err() == context.DeadlineExceeded {
+		out.timedOut = true
+		return out, nil
+	}
+	// The run was stopped from outside, its result says nothing about the submission
+	if err := ctx.Err(); err != nil {
+		return out, err
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		out.exitCode = exitErr.ExitCode()
+		out.timedOut, out.outOfMemory = limitExceeded(exitErr.ProcessState, limits)
+		return out, nil
+	}
+	if err != nil {
+		return out, fmt.Errorf("sandbox failed to start: %w", err)
+	}
+	return out, nil
+}
+
+// cappedBuffer keeps the first limit bytes written and silently drops the rest,
+// so a submission printing in a loop cannot exhaust the server's memory
+type cappedBuffer struct {
+	bytes.Buffer
+	limit int
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.Len(); room > 0 {
+		if len(p) > room {
+			b.Buffer.Write(p[:room])
+		} else {
+			b.Buffer.Write(p)
+		}
+	}
+	return len(p), nil
+}
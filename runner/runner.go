@@ -0,0 +1,93 @@
+package runner
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+)
+
+// TestCase is one input fed to a submission on stdin and the stdout it has to print.
+// Hidden test cases are never shown to candidates, not even after grading.
+type TestCase struct {
+	Input          string `json:"input"`
+	ExpectedOutput string `json:"expectedOutput"`
+	Hidden         bool   `json:"hidden,omitempty"`
+}
+
+// Limits applies to every run of a submission
+type Limits struct {
+	Timeout     time.Duration // wall clock, CPU time is capped to the same
+	MemoryBytes int64
+}
+
+// Request carries a submission and the test cases it is judged against
+type Request struct {
+	Language  string
+	Source    string
+	TestCases []TestCase
+	Limits    Limits
+}
+
+// TestCaseResult is the outcome of one test case, Status is passed, wrong-answer,
+// time-limit-exceeded, memory-limit-exceeded, runtime-error or compile-error
+type TestCaseResult struct {
+	Status     string `json:"status"`
+	Hidden     bool   `json:"hidden,omitempty"`
+	Stdout     string `json:"stdout,omitempty"`
+	Stderr     string `json:"stderr,omitempty"`
+	DurationMs int64  `json:"durationMs"`
+}
+
+// Result has one entry per test case in the order of the request
+type Result struct {
+	CompileError string           `json:"compileError,omitempty"`
+	TestCases    []TestCaseResult `json:"testCases"`
+	Passed       int              `json:"passed"`
+}
+
+// Runner executes submissions in a sandbox. Run only returns an error when the sandbox itself
+// failed, a submission that does not compile or crashes is a normal result.
+type Runner interface {
+	Supports(language string) bool
+	Run(ctx context.Context, req Request) (Result, error)
+}
+
+// CodeRunner runs the answers to code questions
+var CodeRunner Runner
+
+// Defaults and caps for the limits an examiner can set on a question
+const (
+	DefaultTimeout     = 2 * time.Second
+	MaxTimeout         = 10 * time.Second
+	DefaultMemoryBytes = 256 << 20
+	MaxMemoryBytes     = 1024 << 20
+	MaxSourceBytes     = 64 << 10
+)
+
+// InitRunner sets up the local process runner with the languages installed on this server.
+// The distroless image has no compilers or interpreters, code answers are only run on a host
+// or image that has them and allows the namespaces the sandbox needs.
+func InitRunner() {
+	local := NewLocalRunner()
+	CodeRunner = local
+	if languages := local.Languages(); len(languages) > 0 {
+		log.Println("Code answers can be run for", strings.Join(languages, ", "))
+	} else {
+		log.Println("Code answers cannot be run on this server, they will wait for manual grading")
+	}
+}
+
+// OutputMatches compares program output ignoring trailing spaces on each line and trailing
+// blank lines, so a missing final newline does not fail a test case
+func OutputMatches(expected, actual string) bool {
+	return normaliseOutput(expected) == normaliseOutput(actual)
+}
+
+func normaliseOutput(s string) string {
+	lines := strings.Split(strings.ReplaceAll(s, "\r\n", "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t")
+	}
+	return strings.TrimRight(strings.Join(lines, "\n"), "\n")
+}
@@ -0,0 +1,384 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// A run starts this binary again under sandboxInit as the first process of its namespaces,
+// RunSandboxInit then builds the sandbox and execs the submission
+const sandboxInit = "qs-sandbox-init"
+
+const (
+	// As root, the run in slot n runs as firstSandboxUID+n, an id no account uses, so runs at
+	// the same time cannot signal each other or share a process limit
+	firstSandboxUID = 990000
+	// The only file system writable by a submission besides its run directory
+	tmpBytes = 64 << 20
+	// A failed run whose peak resident memory reached this share of its memory limit is
+	// reported as out of memory, the rest of the address space holds code and libraries
+	nearMemoryLimit = 0.8
+)
+
+// Host paths a submission can see, read-only. Interpreters, compilers and their libraries
+// live under /usr, the others are often only links into it.
+var sandboxSystemPaths = []string{"/usr", "/bin", "/lib", "/lib64", "/lib32", "/etc/ld.so.cache", "/etc/alternatives"}
+
+var sandboxDevices = []string{"/dev/null", "/dev/zero", "/dev/random", "/dev/urandom"}
+
+// sandboxConfig is passed to RunSandboxInit on its command line
+type sandboxConfig struct {
+	Dir         string // holds work, the submission's directory, and root, where its file system is built
+	UID         int    // set when the server runs as root
+	MemoryBytes int64
+	CPUSeconds  int
+	Check       bool // only build the sandbox, see checkSandbox
+}
+
+// sandboxCommand starts argv in a sandbox of its own: new network (no interfaces), process,
+// IPC, UTS and mount namespaces, and a file system holding nothing but read-only system paths,
+// its run directory, a private /tmp and a /proc of its own. As root it runs under a uid of its
+// own slot, otherwise an unprivileged user namespace makes the namespaces possible. Once cmd
+// has run, setupErr returns why the sandbox could not be set up, or nil if argv started.
+func sandboxCommand(ctx context.Context, dir string, slot int, argv []string, limits Limits) (cmd *exec.Cmd, setupErr func() error, err error) {
+	config := sandboxConfig{
+		Dir:         dir,
+		MemoryBytes: limits.MemoryBytes,
+		CPUSeconds:  cpuSeconds(limits),
+	}
+	if os.Geteuid() == 0 {
+		config.UID = firstSandboxUID + slot
+	}
+	return sandboxInitCommand(ctx, config, argv)
+}
+
+func sandboxInitCommand(ctx context.Context, config sandboxConfig, argv []string) (*exec.Cmd, func() error, error) {
+	configJSON, err := json.Marshal(config)
+	if err != nil {
+		return nil, nil, err
+	}
+	errRead, errWrite, err := os.Pipe()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cmd := exec.CommandContext(ctx, "/proc/self/exe", append([]string{string(configJSON)}, argv...)...)
+	cmd.Args[0] = sandboxInit
+	cmd.ExtraFiles = []*os.File{errWrite}
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags: syscall.CLONE_NEWNS | syscall.CLONE_NEWNET | syscall.CLONE_NEWPID | syscall.CLONE_NEWIPC | syscall.CLONE_NEWUTS,
+		Pdeathsig:  syscall.SIGKILL,
+	}
+	if config.UID == 0 {
+		uid, gid := os.Geteuid(), os.Getegid()
+		cmd.SysProcAttr.Cloneflags |= syscall.CLONE_NEWUSER
+		cmd.SysProcAttr.UidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: uid, Size: 1}}
+		cmd.SysProcAttr.GidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: gid, Size: 1}}
+	}
+
+	setupErr := func() error {
+		// The child's end closed when it exec'd or exited, closing ours ends the read
+		errWrite.Close()
+		defer errRead.Close()
+		message, err := io.ReadAll(errRead)
+		if err != nil {
+			return err
+		}
+		if len(message) > 0 {
+			return errors.New(string(message))
+		}
+		return nil
+	}
+	return cmd, setupErr, nil
+}
+
+// checkSandbox builds a sandbox once without running anything in it, so the runner can be
+// disabled on hosts where namespaces or mounts are not allowed
+func checkSandbox() error {
+	dir, err := os.MkdirTemp("", "qs-run-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	if err := os.Mkdir(filepath.Join(dir, "work"), 0o755); err != nil {
+		return err
+	}
+	if err := prepareSandboxDir(dir, 0); err != nil {
+		return err
+	}
+
+	config := sandboxConfig{Dir: dir, Check: true}
+	if os.Geteuid() == 0 {
+		config.UID = firstSandboxUID
+	}
+	cmd, setupErr, err := sandboxInitCommand(context.Background(), config, nil)
+	if err != nil {
+		return err
+	}
+	runErr := cmd.Run()
+	if err := setupErr(); err != nil {
+		return err
+	}
+	return runErr
+}
+
+// prepareSandboxDir creates the mount point of the run's file system and, as root, hands the
+// run's work directory to the uid of its slot
+func prepareSandboxDir(dir string, slot int) error {
+	if err := os.Mkdir(filepath.Join(dir, "root"), 0o755); err != nil {
+		return err
+	}
+	if os.Geteuid() != 0 {
+		return nil
+	}
+	uid := firstSandboxUID + slot
+	return filepath.WalkDir(filepath.Join(dir, "work"), func(path string, _ os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		return os.Lchown(path, uid, uid)
+	})
+}
+
+// RunSandboxInit returns straight away unless this process was started by the runner to set up
+// a sandbox, in which case it builds the sandbox and becomes the submission, it never returns.
+// It must be called first thing in main.
+func RunSandboxInit() {
+	if len(os.Args) < 2 || os.Args[0] != sandboxInit {
+		return
+	}
+	// The pipe to the runner closes when the submission starts, or carries why it did not
+	errWrite := os.NewFile(3, "sandbox-errors")
+	syscall.CloseOnExec(3)
+
+	var config sandboxConfig
+	err := json.Unmarshal([]byte(os.Args[1]), &config)
+	if err == nil {
+		err = enterSandbox(config)
+	}
+	if err == nil && config.Check {
+		os.Exit(0)
+	}
+	if err == nil {
+		err = execSubmission(config, os.Args[2:])
+	}
+	fmt.Fprint(errWrite, "sandbox: ", err)
+	os.Exit(1)
+}
+
+// enterSandbox builds the run's file system under Dir/root and makes it the root
+func enterSandbox(config sandboxConfig) error {
+	root := filepath.Join(config.Dir, "root")
+
+	// Nothing mounted from here on is seen outside the run's mount namespace
+	if err := unix.Mount("", "/", "", unix.MS_REC|unix.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("making mounts private: %w", err)
+	}
+	if err := unix.Mount("tmpfs", root, "tmpfs", unix.MS_NOSUID|unix.MS_NODEV, "size=1m,mode=0755"); err != nil {
+		return fmt.Errorf("mounting the root: %w", err)
+	}
+	for _, path := range sandboxSystemPaths {
+		if err := bindReadOnly(path, root+path); err != nil {
+			return fmt.Errorf("mounting %s: %w", path, err)
+		}
+	}
+	for _, device := range sandboxDevices {
+		if err := bindDevice(device, root+device); err != nil {
+			return fmt.Errorf("mounting %s: %w", device, err)
+		}
+	}
+	if err := os.Mkdir(root+"/work", 0o755); err != nil {
+		return err
+	}
+	if err := unix.Mount(filepath.Join(config.Dir, "work"), root+"/work", "", unix.MS_BIND|unix.MS_NOSUID|unix.MS_NODEV, ""); err != nil {
+		return fmt.Errorf("mounting the work directory: %w", err)
+	}
+	if err := os.Mkdir(root+"/tmp", 0o755); err != nil {
+		return err
+	}
+	if err := unix.Mount("tmpfs", root+"/tmp", "tmpfs", unix.MS_NOSUID|unix.MS_NODEV, fmt.Sprintf("size=%d,mode=1777", tmpBytes)); err != nil {
+		return fmt.Errorf("mounting /tmp: %w", err)
+	}
+	// Shows only the run's own processes. It is mounted while the host /proc is still
+	// reachable, the kernel refuses a new /proc in a user namespace that cannot see one.
+	if err := os.Mkdir(root+"/proc", 0o755); err != nil {
+		return err
+	}
+	if err := unix.Mount("proc", root+"/proc", "proc", unix.MS_NOSUID|unix.MS_NODEV|unix.MS_NOEXEC, ""); err != nil {
+		return fmt.Errorf("mounting /proc: %w", err)
+	}
+
+	// Swap the host's file system for the run's and drop it
+	if err := os.Mkdir(root+"/.host", 0o700); err != nil {
+		return err
+	}
+	if err := unix.PivotRoot(root, root+"/.host"); err != nil {
+		return fmt.Errorf("pivoting to the sandbox root: %w", err)
+	}
+	if err := unix.Chdir("/"); err != nil {
+		return err
+	}
+	if err := unix.Unmount("/.host", unix.MNT_DETACH); err != nil {
+		return fmt.Errorf("detaching the host file system: %w", err)
+	}
+	if err := os.Remove("/.host"); err != nil {
+		return err
+	}
+	if err := unix.Mount("", "/", "", unix.MS_REMOUNT|unix.MS_RDONLY|unix.MS_NOSUID|unix.MS_NODEV, ""); err != nil {
+		return fmt.Errorf("making the root read-only: %w", err)
+	}
+	return unix.Chdir("/work")
+}
+
+// bindReadOnly makes the host path visible read-only at target. Links are copied as links, so
+// /bin -> usr/bin still resolves inside the sandbox. Missing paths are skipped.
+func bindReadOnly(path, target string) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return err
+	}
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		link, err := os.Readlink(path)
+		if err != nil {
+			return err
+		}
+		return os.Symlink(link, target)
+	case info.IsDir():
+		err = os.Mkdir(target, 0o755)
+	default:
+		err = os.WriteFile(target, nil, 0o644)
+	}
+	if err != nil {
+		return err
+	}
+	if err := unix.Mount(path, target, "", unix.MS_BIND|unix.MS_REC, ""); err != nil {
+		return err
+	}
+	// A remount inside a user namespace has to keep the flags the host mount was locked with
+	var fs unix.Statfs_t
+	if err := unix.Statfs(path, &fs); err != nil {
+		return err
+	}
+	return unix.Mount("", target, "", unix.MS_BIND|unix.MS_REMOUNT|unix.MS_RDONLY|unix.MS_NOSUID|unix.MS_NODEV|lockedMountFlags(fs.Flags), "")
+}
+
+// lockedMountFlags translates the statfs flags a remount must repeat into mount flags
+func lockedMountFlags(statfsFlags int64) uintptr {
+	var flags uintptr
+	for st, ms := range map[int64]uintptr{
+		unix.ST_NOEXEC:     unix.MS_NOEXEC,
+		unix.ST_NOATIME:    unix.MS_NOATIME,
+		unix.ST_NODIRATIME: unix.MS_NODIRATIME,
+		unix.ST_RELATIME:   unix.MS_RELATIME,
+	} {
+		if statfsFlags&st != 0 {
+			flags |= ms
+		}
+	}
+	return flags
+}
+
+// bindDevice makes a host device node usable at target, the tmpfs root cannot hold device nodes
+func bindDevice(device, target string) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(target, nil, 0o666); err != nil {
+		return err
+	}
+	return unix.Mount(device, target, "", unix.MS_BIND, "")
+}
+
+// execSubmission drops to the run's uid, applies the limits and replaces this process with argv.
+// In a user namespace the submission stays root of the namespace, an empty capability bounding
+// set leaves it no capabilities once it is exec'd.
+func execSubmission(config sandboxConfig, argv []string) error {
+	if len(argv) == 0 {
+		return errors.New("nothing to run")
+	}
+	for capability := 0; capability <= unix.CAP_LAST_CAP; capability++ {
+		if err := unix.Prctl(unix.PR_CAPBSET_DROP, uintptr(capability), 0, 0, 0); err != nil && err != unix.EINVAL {
+			return fmt.Errorf("dropping capability %d: %w", capability, err)
+		}
+	}
+	if config.UID != 0 {
+		if err := unix.Setgroups(nil); err != nil {
+			return err
+		}
+		if err := unix.Setgid(config.UID); err != nil {
+			return err
+		}
+		if err := unix.Setuid(config.UID); err != nil {
+			return err
+		}
+	}
+	// Nothing in the sandbox can gain privileges, setuid binaries included
+	if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		return err
+	}
+
+	limits := []struct {
+		resource int
+		value    uint64
+	}{
+		{unix.RLIMIT_AS, uint64(config.MemoryBytes)},
+		{unix.RLIMIT_CPU, uint64(config.CPUSeconds)},
+		{unix.RLIMIT_FSIZE, maxFileBytes},
+		{unix.RLIMIT_CORE, 0},
+		{unix.RLIMIT_NPROC, maxProcesses},
+	}
+	for _, limit := range limits {
+		if err := unix.Setrlimit(limit.resource, &unix.Rlimit{Cur: limit.value, Max: limit.value}); err != nil {
+			return fmt.Errorf("setting resource limit %d: %w", limit.resource, err)
+		}
+	}
+
+	path, err := exec.LookPath(argv[0])
+	if err != nil {
+		return err
+	}
+	return unix.Exec(path, argv, os.Environ())
+}
+
+// cpuSeconds is the CPU time limit of a run, a second more than its time limit rounded down
+func cpuSeconds(limits Limits) int {
+	return int(limits.Timeout.Seconds()) + 1
+}
+
+// limitExceeded tells which limit stopped a run that failed. The CPU time limit sends SIGXCPU,
+// or SIGKILL as its soft and hard limits are the same, so a kill is only a time limit when the
+// run used up its CPU time. The memory limit makes allocations fail rather than kill the run,
+// a run that failed with most of its memory limit resident most likely ran out of it.
+func limitExceeded(state *os.ProcessState, limits Limits) (timedOut, outOfMemory bool) {
+	if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		used := state.UserTime() + state.SystemTime()
+		switch {
+		case status.Signal() == syscall.SIGXCPU:
+			return true, false
+		case status.Signal() == syscall.SIGKILL && used >= time.Duration(cpuSeconds(limits))*time.Second:
+			return true, false
+		}
+	}
+	if usage, ok := state.SysUsage().(*syscall.Rusage); ok && limits.MemoryBytes > 0 {
+		// Maxrss is in kilobytes
+		return false, float64(usage.Maxrss<<10) >= nearMemoryLimit*float64(limits.MemoryBytes)
+	}
+	return false, false
+}
@@ -0,0 +1,32 @@
+//go:build !linux
+
+package runner
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+)
+
+// The local runner only isolates submissions on Linux, elsewhere it refuses to run them
+var errNoSandbox = errors.New("running code answers needs the Linux sandbox")
+
+func sandboxCommand(ctx context.Context, dir string, slot int, argv []string, limits Limits) (*exec.Cmd, func() error, error) {
+	return nil, nil, errNoSandbox
+}
+
+func checkSandbox() error {
+	return errNoSandbox
+}
+
+func prepareSandboxDir(dir string, slot int) error {
+	return errNoSandbox
+}
+
+// RunSandboxInit has nothing to do without the Linux sandbox
+func RunSandboxInit() {}
+
+func limitExceeded(state *os.ProcessState, limits Limits) (timedOut, outOfMemory bool) {
+	return false, false
+}
@@ -0,0 +1,51 @@
+package spreadsheet
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Sheet is a single table of cells, the first row is written as the header.
+// Cells hold strings, ints, float64s or nil for an empty cell.
+type Sheet struct {
+	Name string
+	Rows [][]interface{}
+}
+
+// WriteCSV writes the sheet as comma separated values
+func WriteCSV(w io.Writer, sheet Sheet) error {
+	cw := csv.NewWriter(w)
+	for _, row := range sheet.Rows {
+		record := make([]string, len(row))
+		for i, cell := range row {
+			record[i] = cellText(cell)
+			// Text that looks like a formula is run by spreadsheet apps on import, quote it
+			if _, isText := cell.(string); isText && record[i] != "" && strings.ContainsRune("=+-@", rune(record[i][0])) {
+				record[i] = "'" + record[i]
+			}
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func cellText(cell interface{}) string {
+	switch v := cell.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case int:
+		return strconv.Itoa(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}